@@ -131,6 +131,74 @@ func readEnvFile(configLookup ConfigLookup, inFile string, serviceData RawServic
 	return serviceData, nil
 }
 
+// flattenBuild turns a build section with context, dockerfile, args and target
+// into the build, dockerfile, build_args and build_target options. Args are
+// either a map or a list of NAME=value, a NAME without a value is looked up in
+// the environment and left out if it is not set there.
+func flattenBuild(environmentLookup EnvironmentLookup, serviceData RawService) (RawService, error) {
+	section, ok := serviceData["build"].(map[interface{}]interface{})
+	if !ok {
+		return serviceData, nil
+	}
+
+	delete(serviceData, "build")
+
+	for k, v := range section {
+		switch asString(k) {
+		case "context":
+			serviceData["build"] = asString(v)
+		case "dockerfile":
+			serviceData["dockerfile"] = v
+		case "target":
+			serviceData["build_target"] = v
+		case "args":
+			args, err := buildArgs(environmentLookup, v)
+			if err != nil {
+				return nil, err
+			}
+			serviceData["build_args"] = args
+		default:
+			return nil, fmt.Errorf("Unsupported build option %v", k)
+		}
+	}
+
+	if asString(serviceData["build"]) == "" {
+		return nil, fmt.Errorf("Build section has no context")
+	}
+
+	return serviceData, nil
+}
+
+func buildArgs(environmentLookup EnvironmentLookup, value interface{}) (map[interface{}]interface{}, error) {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		return typed, nil
+	case []interface{}:
+		args := map[interface{}]interface{}{}
+		for _, item := range typed {
+			parts := strings.SplitN(fmt.Sprint(item), "=", 2)
+			if len(parts) == 2 {
+				args[parts[0]] = parts[1]
+				continue
+			}
+
+			if environmentLookup == nil {
+				continue
+			}
+			values := environmentLookup.Lookup(parts[0], "", nil)
+			if len(values) == 0 {
+				continue
+			}
+			if value := strings.SplitN(values[0], "=", 2); len(value) == 2 {
+				args[parts[0]] = value[1]
+			}
+		}
+		return args, nil
+	}
+
+	return nil, fmt.Errorf("Build args must be a map or a list")
+}
+
 func resolveBuild(inFile string, serviceData RawService) (RawService, error) {
 
 	build := asString(serviceData["build"])
@@ -158,7 +226,12 @@ func resolveBuild(inFile string, serviceData RawService) (RawService, error) {
 }
 
 func parse(configLookup ConfigLookup, environmentLookup EnvironmentLookup, inFile string, serviceData RawService, datas RawServiceMap) (RawService, error) {
-	serviceData, err := readEnvFile(configLookup, inFile, serviceData)
+	serviceData, err := flattenBuild(environmentLookup, serviceData)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceData, err = readEnvFile(configLookup, inFile, serviceData)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,82 @@
+package project
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testEnvLookup map[string][]string
+
+func (t testEnvLookup) Lookup(key, serviceName string, config *ServiceConfig) []string {
+	return t[key]
+}
+
+func TestBuildArgs(t *testing.T) {
+	lookup := testEnvLookup{
+		"FROM_ENV": {"FROM_ENV=env value"},
+		"BROKEN":   {"BROKEN"},
+	}
+
+	args, err := buildArgs(lookup, []interface{}{"VERSION=1.0", "EMPTY=", "FROM_ENV", "MISSING", "BROKEN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[interface{}]interface{}{
+		"VERSION":  "1.0",
+		"EMPTY":    "",
+		"FROM_ENV": "env value",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatal("Unexpected args", args)
+	}
+
+	args, err = buildArgs(nil, map[interface{}]interface{}{"VERSION": "1.0"})
+	if err != nil || !reflect.DeepEqual(args, map[interface{}]interface{}{"VERSION": "1.0"}) {
+		t.Fatal("Expected map args to be kept", args, err)
+	}
+
+	if _, err := buildArgs(nil, "VERSION=1.0"); err == nil {
+		t.Fatal("Expected args that are neither a map nor a list to fail")
+	}
+}
+
+func TestFlattenBuild(t *testing.T) {
+	serviceData, err := flattenBuild(nil, RawService{
+		"image": "web",
+		"build": map[interface{}]interface{}{
+			"context":    "./web",
+			"dockerfile": "Dockerfile.prod",
+			"target":     "app",
+			"args":       []interface{}{"VERSION=1.0"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := RawService{
+		"image":        "web",
+		"build":        "./web",
+		"dockerfile":   "Dockerfile.prod",
+		"build_target": "app",
+		"build_args":   map[interface{}]interface{}{"VERSION": "1.0"},
+	}
+	if !reflect.DeepEqual(serviceData, expected) {
+		t.Fatal("Unexpected service", serviceData)
+	}
+
+	// A plain build context is left alone
+	serviceData, err = flattenBuild(nil, RawService{"build": "./web"})
+	if err != nil || !reflect.DeepEqual(serviceData, RawService{"build": "./web"}) {
+		t.Fatal("Expected plain build to be kept", serviceData, err)
+	}
+
+	if _, err := flattenBuild(nil, RawService{"build": map[interface{}]interface{}{"dockerfile": "Dockerfile"}}); err == nil {
+		t.Fatal("Expected build without context to fail")
+	}
+
+	if _, err := flattenBuild(nil, RawService{"build": map[interface{}]interface{}{"context": ".", "cache_from": "web"}}); err == nil {
+		t.Fatal("Expected unsupported build option to fail")
+	}
+}
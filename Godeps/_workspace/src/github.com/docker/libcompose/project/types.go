@@ -161,6 +161,8 @@ type Info []InfoPart
 // ServiceConfig holds libcompose service configuration
 type ServiceConfig struct {
 	Build         string            `yaml:"build,omitempty"`
+	BuildArgs     map[string]string `yaml:"build_args,omitempty"`
+	BuildTarget   string            `yaml:"build_target,omitempty"`
 	CapAdd        []string          `yaml:"cap_add,omitempty"`
 	CapDrop       []string          `yaml:"cap_drop,omitempty"`
 	CPUSet        string            `yaml:"cpuset,omitempty"`
@@ -6,7 +6,11 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/libcompose/docker"
@@ -18,23 +22,91 @@ type Uploader interface {
 	Name() string
 }
 
+// upload is the result of uploading one build context, shared by all services
+// of a project that build the same context with the same Dockerfile.
+type upload struct {
+	once  sync.Once
+	image string
+	url   string
+	err   error
+}
+
+// Upload uploads the build context of the service name. Services whose context
+// and Dockerfile are identical are uploaded once and get the same image.
 func Upload(c *Context, name string) (string, string, error) {
 	uploader := c.Uploader
 	if uploader == nil {
 		return "", "", errors.New("Build not supported")
 	}
 	p := c.Project
-	logrus.Infof("Uploading build for %s using provider %s", name, uploader.Name())
 
 	content, hash, err := createBuildArchive(p, name)
 	if err != nil {
 		return "", "", err
 	}
+	defer content.Close()
+
+	u, first := c.upload(hash + "\n" + p.Configs[name].Dockerfile)
+	if !first {
+		logrus.Infof("Sharing build of %s with a service that has the same build context", name)
+	}
+
+	u.once.Do(func() {
+		logrus.Infof("Uploading build for %s using provider %s", name, uploader.Name())
+		u.image, u.url, u.err = uploader.Upload(p, name, content, hash)
+	})
+
+	return u.image, u.url, u.err
+}
+
+// upload returns the upload for key and whether it was just added.
+func (c *Context) upload(key string) (*upload, bool) {
+	c.uploadsLock.Lock()
+	defer c.uploadsLock.Unlock()
+
+	if c.uploads == nil {
+		c.uploads = map[string]*upload{}
+	}
 
-	return uploader.Upload(p, name, content, hash)
+	u, ok := c.uploads[key]
+	if !ok {
+		u = &upload{}
+		c.uploads[key] = u
+	}
+	return u, !ok
 }
 
-func createBuildArchive(p *project.Project, name string) (io.ReadSeeker, string, error) {
+// remoteVersion identifies the content of a remote build context by the ETag
+// or Last-Modified header of an HTTP context. It returns "" for other
+// contexts or if the server sends neither.
+func remoteVersion(url string) string {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return ""
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Head(url)
+	if err != nil {
+		logrus.Warnf("Failed to check build context %s, it will be rebuilt: %v", url, err)
+		return ""
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return "etag:" + etag
+	}
+	if modified := resp.Header.Get("Last-Modified"); modified != "" {
+		return "modified:" + modified
+	}
+	return ""
+}
+
+func createBuildArchive(p *project.Project, name string) (*os.File, string, error) {
 	tar, err := docker.CreateTar(p, name)
 	if err != nil {
 		return nil, "", err
@@ -54,7 +126,11 @@ func createBuildArchive(p *project.Project, name string) (io.ReadSeeker, string,
 	digest := sha256.New()
 	output := io.MultiWriter(tempFile, digest)
 
-	_, err = io.Copy(output, tar)
+	if serviceConfig := p.Configs[name]; len(serviceConfig.BuildArgs) > 0 || serviceConfig.BuildTarget != "" {
+		err = applyBuildOptions(name, serviceConfig, tar, output)
+	} else {
+		_, err = io.Copy(output, tar)
+	}
 	if err != nil {
 		tempFile.Close()
 		return nil, "", err
@@ -29,6 +29,19 @@ func populateCerts(client *rancherClient.RancherClient, lbService *rancherClient
 }
 
 func findCertByName(client *rancherClient.RancherClient, name string) (string, error) {
+	cert, err := findCert(client, name)
+	if err != nil {
+		return "", err
+	}
+
+	if cert == nil {
+		return "", fmt.Errorf("Failed to find certificate %s", name)
+	}
+
+	return cert.Id, nil
+}
+
+func findCert(client *rancherClient.RancherClient, name string) (*rancherClient.Certificate, error) {
 	certs, err := client.Certificate.List(&rancherClient.ListOpts{
 		Filters: map[string]interface{}{
 			"removed_null": nil,
@@ -37,12 +50,12 @@ func findCertByName(client *rancherClient.RancherClient, name string) (string, e
 	})
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if len(certs.Data) == 0 {
-		return "", fmt.Errorf("Failed to find certificate %s", name)
+		return nil, nil
 	}
 
-	return certs.Data[0].Id, nil
+	return &certs.Data[0], nil
 }
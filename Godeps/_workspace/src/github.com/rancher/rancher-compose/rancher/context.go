@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v2"
 
@@ -37,6 +38,36 @@ type Context struct {
 	SidekickInfo        *SidekickInfo
 	Uploader            Uploader
 	PullCached          bool
+	Defaults            Defaults
+	LaunchConfigFilters []LaunchConfigFilter
+	// PullProgress, if set, is called with every new status of an image pull
+	PullProgress func(image, message string)
+
+	uploadsLock sync.Mutex
+	uploads     map[string]*upload
+}
+
+// LaunchConfigFilter can inspect and modify every launch config, including
+// those of sidekicks, after it is built and before it is sent to the API.
+type LaunchConfigFilter interface {
+	Filter(name string, serviceConfig *project.ServiceConfig, launchConfig *rancherClient.LaunchConfig) error
+}
+
+// ImagePinner is a LaunchConfigFilter that replaces images with pinned
+// references. Images are pulled by the reference the services will run.
+type ImagePinner interface {
+	Pinned(image string) (string, bool)
+}
+
+func (c *Context) pinnedImage(image string) string {
+	for _, filter := range c.LaunchConfigFilters {
+		if pinner, ok := filter.(ImagePinner); ok {
+			if pinned, ok := pinner.Pinned(image); ok {
+				return pinned
+			}
+		}
+	}
+	return image
 }
 
 type RancherConfig struct {
@@ -48,6 +79,7 @@ type RancherConfig struct {
 	DefaultCert        string                             `yaml:"default_cert,omitempty"`
 	Certs              []string                           `yaml:"certs,omitempty"`
 	Metadata           map[string]interface{}             `yaml:"metadata,omitempty"`
+	DependsOn          DependsOnMap                       `yaml:"depends_on,omitempty"`
 }
 
 func (c *Context) readRancherConfig() error {
@@ -0,0 +1,34 @@
+package rancher
+
+import (
+	"testing"
+
+	"github.com/docker/libcompose/project"
+	rancherClient "github.com/rancher/go-rancher/client"
+)
+
+type testPinner map[string]string
+
+func (t testPinner) Filter(name string, serviceConfig *project.ServiceConfig, launchConfig *rancherClient.LaunchConfig) error {
+	return nil
+}
+
+func (t testPinner) Pinned(image string) (string, bool) {
+	pinned, ok := t[image]
+	return pinned, ok
+}
+
+func TestPinnedImage(t *testing.T) {
+	c := &Context{}
+	if image := c.pinnedImage("nginx"); image != "nginx" {
+		t.Fatal("Expected the image without filters, got", image)
+	}
+
+	c.LaunchConfigFilters = []LaunchConfigFilter{testPinner{"nginx": "nginx@sha256:abc"}}
+	if image := c.pinnedImage("nginx"); image != "nginx@sha256:abc" {
+		t.Fatal("Expected the pinned image, got", image)
+	}
+	if image := c.pinnedImage("redis"); image != "redis" {
+		t.Fatal("Expected an image that is not pinned as is, got", image)
+	}
+}
@@ -0,0 +1,97 @@
+package rancher
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libcompose/project"
+)
+
+// Defaults are stack wide values that are merged into every service. Values
+// set on the service itself always win.
+type Defaults struct {
+	Labels      map[string]string      `yaml:"labels,omitempty"`
+	Environment map[string]string      `yaml:"environment,omitempty"`
+	Metadata    map[string]interface{} `yaml:"metadata,omitempty"`
+}
+
+func (c *Context) applyDefaults(p *project.Project) {
+	if len(c.Defaults.Labels) == 0 && len(c.Defaults.Environment) == 0 && len(c.Defaults.Metadata) == 0 {
+		return
+	}
+
+	if c.RancherConfig == nil {
+		c.RancherConfig = map[string]RancherConfig{}
+	}
+
+	for name, config := range p.Configs {
+		if len(c.Defaults.Labels) > 0 {
+			config.Labels = project.NewSliceorMap(MapUnion(c.Defaults.Labels, config.Labels.MapParts()))
+		}
+
+		if len(c.Defaults.Environment) > 0 {
+			config.Environment = mergeEnvironment(c.Defaults.Environment, config.Environment)
+		}
+
+		if len(c.Defaults.Metadata) > 0 {
+			rancherConfig := c.RancherConfig[name]
+			metadata := map[string]interface{}{}
+			for k, v := range c.Defaults.Metadata {
+				metadata[k] = v
+			}
+			for k, v := range rancherConfig.Metadata {
+				metadata[k] = v
+			}
+			rancherConfig.Metadata = metadata
+			c.RancherConfig[name] = rancherConfig
+		}
+
+		// Values may be interpolated secrets, only the keys are logged
+		logrus.Debugf("Applied stack defaults to %s: labels=%v environment=%v metadata=%v", name,
+			keys(config.Labels.MapParts()), envKeys(config.Environment), metadataKeys(c.RancherConfig[name].Metadata))
+	}
+}
+
+func mergeEnvironment(defaults map[string]string, env project.MaporEqualSlice) project.MaporEqualSlice {
+	result := env.Slice()
+	set := map[string]bool{}
+	for _, item := range result {
+		set[strings.SplitN(item, "=", 2)[0]] = true
+	}
+
+	for k, v := range defaults {
+		if !set[k] {
+			result = append(result, k+"="+v)
+		}
+	}
+
+	return project.NewMaporEqualSlice(result)
+}
+
+func keys(m map[string]string) []string {
+	result := []string{}
+	for k := range m {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func envKeys(env project.MaporEqualSlice) []string {
+	result := []string{}
+	for _, item := range env.Slice() {
+		result = append(result, strings.SplitN(item, "=", 2)[0])
+	}
+	sort.Strings(result)
+	return result
+}
+
+func metadataKeys(m map[string]interface{}) []string {
+	result := []string{}
+	for k := range m {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
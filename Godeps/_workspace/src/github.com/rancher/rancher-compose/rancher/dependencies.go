@@ -0,0 +1,157 @@
+package rancher
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libcompose/project"
+	rancherClient "github.com/rancher/go-rancher/client"
+)
+
+const (
+	ConditionStarted = "started"
+	ConditionHealthy = "healthy"
+
+	RelTypeDependsOn = project.ServiceRelationshipType("dependsOn")
+
+	defaultDependsOnTimeout = 300
+)
+
+type DependsOn struct {
+	Condition string `yaml:"condition,omitempty"`
+	Timeout   int    `yaml:"timeout,omitempty"`
+}
+
+// DependsOnMap accepts both the list form (depends_on: [db]) and the map form
+// (depends_on: {db: {condition: healthy}}) of depends_on.
+type DependsOnMap map[string]DependsOn
+
+func (d *DependsOnMap) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		result := DependsOnMap{}
+		for _, name := range list {
+			result[name] = DependsOn{}
+		}
+		*d = result
+		return nil
+	}
+
+	var parsed map[string]DependsOn
+	if err := unmarshal(&parsed); err != nil {
+		return err
+	}
+
+	for name, dep := range parsed {
+		switch dep.Condition {
+		case "", ConditionStarted, ConditionHealthy:
+		default:
+			return fmt.Errorf("Invalid depends_on condition %s for %s", dep.Condition, name)
+		}
+	}
+
+	*d = DependsOnMap(parsed)
+	return nil
+}
+
+func (r *RancherService) getDependsOn() DependsOnMap {
+	if config, ok := r.context.RancherConfig[r.name]; ok {
+		return config.DependsOn
+	}
+	return nil
+}
+
+func (r *RancherService) dependsOnRelationships() []project.ServiceRelationship {
+	result := []project.ServiceRelationship{}
+	for name := range r.getDependsOn() {
+		result = append(result, project.ServiceRelationship{
+			Target: name,
+			Alias:  name,
+			Type:   RelTypeDependsOn,
+		})
+	}
+	return result
+}
+
+// waitForDependencies blocks until every depends_on entry with condition
+// healthy reports only healthy containers. A dependency that is still inactive
+// is activated first, otherwise its containers would never become healthy.
+func (r *RancherService) waitForDependencies() error {
+	for name, dep := range r.getDependsOn() {
+		if dep.Condition != ConditionHealthy {
+			continue
+		}
+
+		if config, ok := r.context.RancherConfig[name]; ok && config.HealthCheck == nil {
+			return fmt.Errorf("Service %s depends on %s being healthy but %s has no health_check", r.name, name, name)
+		}
+
+		timeout := dep.Timeout
+		if timeout <= 0 {
+			timeout = defaultDependsOnTimeout
+		}
+
+		logrus.Infof("Waiting for %s to be healthy before starting %s", name, r.name)
+		if err := r.waitHealthy(name, time.Duration(timeout)*time.Second); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *RancherService) waitHealthy(name string, timeout time.Duration) error {
+	service, err := r.findExisting(name)
+	if err != nil {
+		return err
+	}
+	if service == nil {
+		return fmt.Errorf("Failed to find service %s that %s depends on", name, r.name)
+	}
+
+	if service.State == "inactive" && service.Actions["activate"] != "" {
+		service, err = r.context.Client.Service.ActionActivate(service)
+		if err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		healthy, err := r.isHealthy(service)
+		if err != nil {
+			return err
+		}
+		if healthy {
+			logrus.Infof("Service %s is healthy", name)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timeout waiting for %s to be healthy before starting %s", name, r.name)
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+}
+
+func (r *RancherService) isHealthy(service *rancherClient.Service) (bool, error) {
+	var instances rancherClient.ContainerCollection
+
+	if err := r.context.Client.GetLink(service.Resource, "instances", &instances); err != nil {
+		return false, err
+	}
+
+	if len(instances.Data) == 0 {
+		return false, nil
+	}
+
+	for _, instance := range instances.Data {
+		if instance.State != "running" || instance.HealthState != "healthy" {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,296 @@
+package rancher
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libcompose/project"
+)
+
+// applyBuildOptions copies the build archive in to out with the build args and
+// target of serviceConfig applied to its Dockerfile, so they are part of the
+// uploaded context and its hash. Args become the defaults of the matching ARG
+// instructions and the Dockerfile is cut after the target stage.
+func applyBuildOptions(name string, serviceConfig *project.ServiceConfig, in io.Reader, out io.Writer) error {
+	dockerfiles := []string{"Dockerfile", "dockerfile"}
+	if serviceConfig.Dockerfile != "" {
+		dockerfiles = []string{path.Clean(filepath.ToSlash(serviceConfig.Dockerfile))}
+	}
+
+	reader := tar.NewReader(in)
+	writer := tar.NewWriter(out)
+	found := false
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if !Contains(dockerfiles, path.Clean(header.Name)) {
+			if err := writer.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := io.Copy(writer, reader); err != nil {
+				return err
+			}
+			continue
+		}
+
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+
+		content, err = rewriteDockerfile(name, content, serviceConfig.BuildArgs, serviceConfig.BuildTarget)
+		if err != nil {
+			return err
+		}
+
+		header.Size = int64(len(content))
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := writer.Write(content); err != nil {
+			return err
+		}
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("Failed to find the Dockerfile of %s to apply build args and target", name)
+	}
+
+	return writer.Close()
+}
+
+// instruction is one instruction of a Dockerfile, which may span the lines
+// from start to end through line continuations. Text is the instruction with
+// the continuations joined.
+type instruction struct {
+	start, end int
+	text       string
+}
+
+// keyword returns the upper cased instruction and the words of its arguments.
+func (i instruction) keyword(escape rune) (string, []string) {
+	w := words(i.text, escape)
+	if len(w) == 0 {
+		return "", nil
+	}
+	return strings.ToUpper(w[0]), w[1:]
+}
+
+// escapeChar returns the escape character set with the escape parser
+// directive at the top of a Dockerfile, a backslash by default.
+func escapeChar(lines []string) rune {
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+		directive := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(trimmed, "#")), "=", 2)
+		if len(directive) == 2 && strings.EqualFold(strings.TrimSpace(directive[0]), "escape") {
+			if value := strings.TrimSpace(directive[1]); value == "`" {
+				return '`'
+			}
+		}
+	}
+	return '\\'
+}
+
+// instructions groups lines into instructions, joining line continuations and
+// skipping comments and blank lines.
+func instructions(lines []string, escape rune) []instruction {
+	result := []instruction{}
+	var current *instruction
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if current == nil && (trimmed == "" || strings.HasPrefix(trimmed, "#")) {
+			continue
+		}
+		if current != nil && strings.HasPrefix(trimmed, "#") {
+			current.end = i
+			continue
+		}
+
+		if current == nil {
+			current = &instruction{start: i}
+		}
+		current.end = i
+
+		if strings.HasSuffix(trimmed, string(escape)) {
+			current.text += strings.TrimSuffix(strings.TrimRight(line, " \t\r"), string(escape))
+			continue
+		}
+
+		current.text += line
+		result = append(result, *current)
+		current = nil
+	}
+
+	if current != nil {
+		result = append(result, *current)
+	}
+
+	return result
+}
+
+// words splits the text of an instruction at whitespace, keeping quoted parts
+// and escaped characters in their word. The words keep their quotes.
+func words(text string, escape rune) []string {
+	result := []string{}
+	word := bytes.Buffer{}
+	inWord := false
+	escaped := false
+	quote := rune(0)
+
+	for _, c := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case c == escape && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case unicode.IsSpace(c):
+			if inWord {
+				result = append(result, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+		word.WriteRune(c)
+		inWord = true
+	}
+
+	if inWord {
+		result = append(result, word.String())
+	}
+	return result
+}
+
+// stageName returns the name a FROM instruction gives its stage, skipping
+// flags such as --platform.
+func stageName(args []string) string {
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		args = args[1:]
+	}
+	if len(args) == 3 && strings.EqualFold(args[1], "AS") {
+		return args[2]
+	}
+	return ""
+}
+
+func rewriteDockerfile(name string, content []byte, args map[string]string, target string) ([]byte, error) {
+	lines := strings.Split(string(content), "\n")
+	escape := escapeChar(lines)
+	parsed := instructions(lines, escape)
+
+	if target != "" {
+		end := -1
+		for _, i := range parsed {
+			keyword, words := i.keyword(escape)
+			if keyword != "FROM" {
+				continue
+			}
+			if end >= 0 {
+				end = i.start
+				break
+			}
+			if strings.EqualFold(stageName(words), target) {
+				end = len(lines)
+			}
+		}
+
+		if end < 0 {
+			return nil, fmt.Errorf("Build target %s of %s is not a stage of its Dockerfile", target, name)
+		}
+		lines = lines[:end]
+	}
+
+	used := map[string]bool{}
+	for _, i := range parsed {
+		if i.start >= len(lines) {
+			break
+		}
+
+		keyword, declared := i.keyword(escape)
+		if keyword != "ARG" {
+			continue
+		}
+
+		changed := false
+		for j, word := range declared {
+			arg := strings.SplitN(word, "=", 2)[0]
+			value, ok := args[arg]
+			if !ok {
+				continue
+			}
+
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("Build arg %s of %s can not span lines", arg, name)
+			}
+
+			declared[j] = fmt.Sprintf("%s=%s", arg, quoteArg(value, escape))
+			used[arg] = true
+			changed = true
+		}
+
+		if !changed {
+			continue
+		}
+
+		// The instruction is written on its first line, the lines of its
+		// continuations are left blank so line numbers stay the same
+		line := lines[i.start]
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		lines[i.start] = fmt.Sprintf("%sARG %s", indent, strings.Join(declared, " "))
+		for k := i.start + 1; k <= i.end; k++ {
+			lines[k] = ""
+		}
+	}
+
+	unused := []string{}
+	for arg := range args {
+		if !used[arg] {
+			unused = append(unused, arg)
+		}
+	}
+	sort.Strings(unused)
+	if len(unused) > 0 {
+		logrus.Warnf("Build args %v of %s are not declared in its Dockerfile", unused, name)
+	}
+
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+func quoteArg(value string, escape rune) string {
+	var buffer bytes.Buffer
+	buffer.WriteString("\"")
+	for _, c := range value {
+		if c == '"' || c == escape || c == '$' {
+			buffer.WriteRune(escape)
+		}
+		buffer.WriteRune(c)
+	}
+	buffer.WriteString("\"")
+	return buffer.String()
+}
@@ -0,0 +1,91 @@
+package rancher
+
+import (
+	"testing"
+)
+
+func TestRewriteDockerfileArgs(t *testing.T) {
+	dockerfile := `FROM busybox
+ARG VERSION
+ARG GREETING="hello world"
+ARG MULTI=1 \
+    OTHER=2
+# ARG VERSION
+ARG KEEP=kept
+RUN echo $VERSION`
+
+	content, err := rewriteDockerfile("web", []byte(dockerfile), map[string]string{
+		"VERSION":  "1.0",
+		"GREETING": `say "$hi"`,
+		"OTHER":    "two words",
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `FROM busybox
+ARG VERSION="1.0"
+ARG GREETING="say \"\$hi\""
+ARG MULTI=1 OTHER="two words"
+
+# ARG VERSION
+ARG KEEP=kept
+RUN echo $VERSION`
+	if string(content) != expected {
+		t.Fatalf("Unexpected Dockerfile:\n%s", content)
+	}
+
+	if _, err := rewriteDockerfile("web", []byte(dockerfile), map[string]string{"VERSION": "1\n2"}, ""); err == nil {
+		t.Fatal("Expected a value spanning lines to fail")
+	}
+}
+
+func TestRewriteDockerfileTarget(t *testing.T) {
+	dockerfile := `FROM --platform=$BUILDPLATFORM golang AS build
+ARG VERSION
+RUN go build
+from busybox as app
+ARG VERSION
+COPY --from=build /app /app
+FROM busybox AS debug`
+
+	content, err := rewriteDockerfile("web", []byte(dockerfile), map[string]string{"VERSION": "1.0"}, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `FROM --platform=$BUILDPLATFORM golang AS build
+ARG VERSION="1.0"
+RUN go build
+from busybox as app
+ARG VERSION="1.0"
+COPY --from=build /app /app`
+	if string(content) != expected {
+		t.Fatalf("Unexpected Dockerfile:\n%s", content)
+	}
+
+	content, err = rewriteDockerfile("web", []byte(dockerfile), nil, "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "FROM --platform=$BUILDPLATFORM golang AS build\nARG VERSION\nRUN go build" {
+		t.Fatalf("Unexpected Dockerfile:\n%s", content)
+	}
+
+	if _, err := rewriteDockerfile("web", []byte(dockerfile), nil, "missing"); err == nil {
+		t.Fatal("Expected a missing target to fail")
+	}
+}
+
+func TestRewriteDockerfileEscape(t *testing.T) {
+	dockerfile := "# escape=`\nFROM microsoft/nanoserver\nARG DIR=C:\\app `\n    VERSION\nRUN dir"
+
+	content, err := rewriteDockerfile("web", []byte(dockerfile), map[string]string{"VERSION": "`1.0`"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "# escape=`\nFROM microsoft/nanoserver\nARG DIR=C:\\app VERSION=\"``1.0``\"\n\nRUN dir" {
+		t.Fatalf("Unexpected Dockerfile:\n%s", content)
+	}
+}
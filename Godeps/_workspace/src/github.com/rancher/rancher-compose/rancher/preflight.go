@@ -0,0 +1,109 @@
+package rancher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	rancherClient "github.com/rancher/go-rancher/client"
+)
+
+// ReferenceError lists every reference of a project that could not be
+// resolved.
+type ReferenceError struct {
+	Missing []string
+}
+
+func (e *ReferenceError) Error() string {
+	return "Missing references: " + strings.Join(e.Missing, "; ")
+}
+
+// CheckReferences resolves the external links, certificates and volumes_from
+// of every service before anything is created. environmentId is the stack the
+// project will be created in. All missing references are returned at once in
+// a *ReferenceError.
+func (c *Context) CheckReferences(environmentId string) error {
+	names := []string{}
+	for name := range c.Project.Configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	missing := []string{}
+	certs := map[string]bool{}
+
+	for _, name := range names {
+		serviceConfig := c.Project.Configs[name]
+
+		for _, link := range serviceConfig.ExternalLinks {
+			target := strings.TrimSpace(strings.SplitN(link, ":", 2)[0])
+			found, err := c.serviceExists(target, environmentId)
+			if err != nil {
+				return err
+			}
+			if !found {
+				missing = append(missing, fmt.Sprintf("service %s: external link %s not found", name, target))
+			}
+		}
+
+		for _, volumesFrom := range serviceConfig.VolumesFrom {
+			target := strings.SplitN(volumesFrom, ":", 2)[0]
+			if _, ok := c.Project.Configs[target]; !ok {
+				missing = append(missing, fmt.Sprintf("service %s: volumes_from %s is not a service in this stack", name, target))
+			}
+		}
+
+		rancherConfig := c.RancherConfig[name]
+		certNames := rancherConfig.Certs
+		if rancherConfig.DefaultCert != "" {
+			certNames = append([]string{rancherConfig.DefaultCert}, certNames...)
+		}
+
+		for _, certName := range certNames {
+			found, ok := certs[certName]
+			if !ok {
+				cert, err := findCert(c.Client, certName)
+				if err != nil {
+					return err
+				}
+				found = cert != nil
+				certs[certName] = found
+			}
+			if !found {
+				missing = append(missing, fmt.Sprintf("service %s: certificate %s not found", name, certName))
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return &ReferenceError{Missing: missing}
+	}
+
+	return nil
+}
+
+func (c *Context) serviceExists(name, environmentId string) (bool, error) {
+	if _, ok := c.Project.Configs[name]; ok {
+		return true, nil
+	}
+
+	serviceName, envId, err := resolveServiceAndEnvironmentId(c.Client, name, environmentId)
+	if _, ok := err.(missingStackError); ok {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	services, err := c.Client.Service.List(&rancherClient.ListOpts{
+		Filters: map[string]interface{}{
+			"environmentId": envId,
+			"name":          serviceName,
+			"removed_null":  nil,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(services.Data) > 0, nil
+}
@@ -25,6 +25,7 @@ func NewProject(context *Context) (*project.Project, error) {
 
 	p.Name = context.ProjectName
 
+	context.applyDefaults(p)
 	context.SidekickInfo = NewSidekickInfo(p)
 
 	return p, err
@@ -1,6 +1,8 @@
 package rancher
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -73,6 +75,9 @@ func (r *RancherService) Create() error {
 	service, err := r.findExisting(r.name)
 
 	if err == nil && service == nil {
+		if err = r.waitForDependencies(); err != nil {
+			return err
+		}
 		service, err = r.createService()
 	}
 
@@ -109,6 +114,12 @@ func (r *RancherService) up(create bool) error {
 		return nil
 	}
 
+	if service == nil || service.State != "active" {
+		if err = r.waitForDependencies(); err != nil {
+			return err
+		}
+	}
+
 	if service == nil {
 		service, err = r.createService()
 	} else {
@@ -174,12 +185,25 @@ func (r *RancherService) Delete() error {
 }
 
 func (r *RancherService) resolveServiceAndEnvironmentId(name string) (string, string, error) {
+	return resolveServiceAndEnvironmentId(r.context.Client, name, r.context.Environment.Id)
+}
+
+type missingStackError string
+
+func (e missingStackError) Error() string {
+	return "Failed to find stack: " + string(e)
+}
+
+// resolveServiceAndEnvironmentId splits a "stack/service" reference into the
+// service name and the id of the stack. A plain service name refers to the
+// stack with id environmentId.
+func resolveServiceAndEnvironmentId(client *rancherClient.RancherClient, name, environmentId string) (string, string, error) {
 	parts := strings.SplitN(name, "/", 2)
 	if len(parts) == 1 {
-		return name, r.context.Environment.Id, nil
+		return name, environmentId, nil
 	}
 
-	envs, err := r.context.Client.Environment.List(&rancherClient.ListOpts{
+	envs, err := client.Environment.List(&rancherClient.ListOpts{
 		Filters: map[string]interface{}{
 			"name":         parts[0],
 			"removed_null": nil,
@@ -191,7 +215,7 @@ func (r *RancherService) resolveServiceAndEnvironmentId(name string) (string, st
 	}
 
 	if len(envs.Data) == 0 {
-		return "", "", fmt.Errorf("Failed to find stack: %s", parts[0])
+		return "", "", missingStackError(parts[0])
 	}
 
 	return parts[1], envs.Data[0].Id, nil
@@ -282,7 +306,7 @@ func (r *RancherService) createLbService() (*rancherClient.Service, error) {
 		lbConfig = config.LoadBalancerConfig
 	}
 
-	launchConfig, err := r.createLaunchConfig(r.serviceConfig)
+	launchConfig, err := r.createLaunchConfig(r.name, r.serviceConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -316,7 +340,7 @@ func (r *RancherService) createLbService() (*rancherClient.Service, error) {
 func (r *RancherService) createNormalService() (*rancherClient.Service, error) {
 	secondaryLaunchConfigs := []interface{}{}
 
-	launchConfig, err := r.createLaunchConfig(r.serviceConfig)
+	launchConfig, err := r.createLaunchConfig(r.name, r.serviceConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -328,7 +352,7 @@ func (r *RancherService) createNormalService() (*rancherClient.Service, error) {
 				return nil, fmt.Errorf("Failed to find sidekick: %s", secondaryName)
 			}
 
-			launchConfig, err := r.createLaunchConfig(serviceConfig)
+			launchConfig, err := r.createLaunchConfig(secondaryName, serviceConfig)
 			if err != nil {
 				return nil, err
 			}
@@ -412,18 +436,35 @@ func (r *RancherService) createService() (*rancherClient.Service, error) {
 
 func (r *RancherService) serviceType() serviceType {
 	rancherConfig, _ := r.context.RancherConfig[r.name]
+	return getServiceType(r.serviceConfig, rancherConfig)
+}
 
+func getServiceType(serviceConfig *project.ServiceConfig, rancherConfig RancherConfig) serviceType {
 	if len(rancherConfig.ExternalIps) > 0 || rancherConfig.Hostname != "" {
 		return externalServiceType
-	} else if r.serviceConfig.Image == LB_IMAGE {
+	} else if serviceConfig.Image == LB_IMAGE {
 		return lbServiceType
-	} else if r.serviceConfig.Image == DNS_IMAGE {
+	} else if serviceConfig.Image == DNS_IMAGE {
 		return dnsServiceType
 	}
 
 	return rancherType
 }
 
+// ServiceKind returns the API type of the service that is created for a
+// compose service, such as service or loadBalancerService.
+func ServiceKind(serviceConfig *project.ServiceConfig, rancherConfig RancherConfig) string {
+	switch getServiceType(serviceConfig, rancherConfig) {
+	case lbServiceType:
+		return rancherClient.LOAD_BALANCER_SERVICE_TYPE
+	case dnsServiceType:
+		return rancherClient.DNS_SERVICE_TYPE
+	case externalServiceType:
+		return rancherClient.EXTERNAL_SERVICE_TYPE
+	}
+	return rancherClient.SERVICE_TYPE
+}
+
 func (r *RancherService) setupLinks(service *rancherClient.Service) error {
 	var err error
 	var links []interface{}
@@ -579,15 +620,27 @@ func (r *RancherService) setupBuild(result *rancherClient.LaunchConfig, serviceC
 				Dockerfile: serviceConfig.Dockerfile,
 			}
 			result.ImageUuid = "docker:" + image
+		} else if len(serviceConfig.BuildArgs) > 0 || serviceConfig.BuildTarget != "" {
+			return fmt.Errorf("Build args and target of %s are not supported for the remote build %s", r.name, serviceConfig.Build)
 		} else if result.ImageUuid == "" {
-			result.ImageUuid = fmt.Sprintf("docker:%s_%s_%d", r.context.ProjectName, r.name, time.Now().UnixNano()/int64(time.Millisecond))
+			if version := remoteVersion(serviceConfig.Build); version != "" {
+				// Name the image after the remote context and its version
+				// so an unchanged context keeps its image and is not
+				// rebuilt, and services building the same context share it
+				sum := sha256.Sum256([]byte(serviceConfig.Build + "\n" + serviceConfig.Dockerfile + "\n" + version))
+				result.ImageUuid = fmt.Sprintf("docker:%s_%s", r.context.ProjectName, hex.EncodeToString(sum[:])[:12])
+			} else {
+				// Without a version the content can not be told apart, so
+				// it is always rebuilt
+				result.ImageUuid = fmt.Sprintf("docker:%s_%s_%d", r.context.ProjectName, r.name, time.Now().UnixNano()/int64(time.Millisecond))
+			}
 		}
 	}
 
 	return nil
 }
 
-func (r *RancherService) createLaunchConfig(serviceConfig *project.ServiceConfig) (rancherClient.LaunchConfig, error) {
+func (r *RancherService) createLaunchConfig(name string, serviceConfig *project.ServiceConfig) (rancherClient.LaunchConfig, error) {
 	var result rancherClient.LaunchConfig
 
 	schemasUrl := strings.SplitN(r.context.Client.Schemas.Links["self"], "/schemas", 2)[0]
@@ -621,8 +674,17 @@ func (r *RancherService) createLaunchConfig(serviceConfig *project.ServiceConfig
 	setupNetworking(serviceConfig.Net, &result)
 	setupVolumesFrom(serviceConfig.VolumesFrom, &result)
 
-	err = r.setupBuild(&result, serviceConfig)
-	return result, err
+	if err = r.setupBuild(&result, serviceConfig); err != nil {
+		return result, err
+	}
+
+	for _, filter := range r.context.LaunchConfigFilters {
+		if err = filter.Filter(name, serviceConfig, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
 }
 
 func (r *RancherService) WaitFor(resource *rancherClient.Resource, output interface{}, transitioning func() string) error {
@@ -822,7 +884,7 @@ func (r *RancherService) DependentServices() []project.ServiceRelationship {
 		}
 	}
 
-	return result
+	return append(result, r.dependsOnRelationships()...)
 }
 
 func (r *RancherService) Client() *rancherClient.RancherClient {
@@ -859,6 +921,9 @@ func (r *RancherService) pullImage(image string, labels map[string]string) error
 		if task.TransitioningMessage != "" && task.TransitioningMessage != "In Progress" && task.TransitioningMessage != lastMessage {
 			printStatus(task.Image, printed, task.Status)
 			lastMessage = task.TransitioningMessage
+			if r.context.PullProgress != nil {
+				r.context.PullProgress(task.Image, lastMessage)
+			}
 		}
 
 		return task.Transitioning
@@ -878,34 +943,42 @@ func (r *RancherService) pullImage(image string, labels map[string]string) error
 
 func (r *RancherService) Pull() (err error) {
 	config := r.Config()
-	if config.Image == "" || r.serviceType() != rancherType {
+	if r.serviceType() != rancherType {
 		return
 	}
 
-	toPull := map[string]bool{config.Image: true}
+	// An image that is built can not be pulled before the build, but the
+	// sidekicks of a built service still are
+	toPull := map[string]bool{}
+	if config.Image != "" && config.Build == "" {
+		toPull[r.context.pinnedImage(config.Image)] = true
+	}
 	labels := config.Labels.MapParts()
 
 	if secondaries, ok := r.context.SidekickInfo.primariesToSidekicks[r.name]; ok {
 		for _, secondaryName := range secondaries {
 			serviceConfig, ok := r.context.Project.Configs[secondaryName]
-			if !ok {
+			if !ok || serviceConfig.Build != "" {
 				continue
 			}
 
 			labels = MapUnion(labels, serviceConfig.Labels.MapParts())
 			if serviceConfig.Image != "" {
-				toPull[serviceConfig.Image] = true
+				toPull[r.context.pinnedImage(serviceConfig.Image)] = true
 			}
 		}
 	}
 
 	wg := sync.WaitGroup{}
+	lock := sync.Mutex{}
 
 	for image := range toPull {
 		wg.Add(1)
 		go func(image string) {
 			if pErr := r.pullImage(image, labels); pErr != nil {
+				lock.Lock()
 				err = pErr
+				lock.Unlock()
 			}
 			wg.Done()
 		}(image)
@@ -0,0 +1,206 @@
+package build
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+var projectPath = regexp.MustCompile("^/v1/projects/([^/]+)(/.+)$")
+
+// fakeCattle serves just enough of the Cattle API for a client to list
+// resources, follow links and run actions. Under /v1/projects/<id> it only
+// lists the resources whose accountId is that project.
+type fakeCattle struct {
+	server *httptest.Server
+
+	lock sync.Mutex
+	// collections maps a plural type name to its resources
+	collections map[string][]map[string]interface{}
+	// links maps a path to the collection it returns
+	links map[string][]map[string]interface{}
+	// actions records the paths actions were posted to
+	actions []string
+	// onAction, if set, is called with the path of every action
+	onAction func(path string)
+	// pageSize splits collections into pages of that many resources
+	pageSize int
+	// partial marks collections as partial without a next page
+	partial bool
+	// failing lists the paths that fail with a server error
+	failing map[string]bool
+}
+
+func newFakeCattle(t *testing.T) (*fakeCattle, *client.RancherClient) {
+	f := &fakeCattle{
+		collections: map[string][]map[string]interface{}{},
+		links:       map[string][]map[string]interface{}{},
+		failing:     map[string]bool{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+
+	apiClient, err := client.NewRancherClient(&client.ClientOpts{
+		Url: f.server.URL + "/v1",
+	})
+	if err != nil {
+		f.server.Close()
+		t.Fatal(err)
+	}
+
+	return f, apiClient
+}
+
+func (f *fakeCattle) Close() {
+	f.server.Close()
+}
+
+func (f *fakeCattle) url(path string) string {
+	return f.server.URL + path
+}
+
+// add stores a resource of the given schema type, filling in its id, type
+// and self link.
+func (f *fakeCattle) add(schemaType, id string, resource map[string]interface{}) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	resource["id"] = id
+	resource["type"] = schemaType
+	links, _ := resource["links"].(map[string]interface{})
+	if links == nil {
+		links = map[string]interface{}{}
+	}
+	links["self"] = f.url("/v1/" + schemaType + "s/" + id)
+	resource["links"] = links
+
+	f.collections[schemaType+"s"] = append(f.collections[schemaType+"s"], resource)
+}
+
+func (f *fakeCattle) serve(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if f.failing[r.URL.Path] {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"type": "error", "status": 500}`))
+		return
+	}
+
+	// A project scoped client sees the resources of that project only
+	prefix, path, account := "/v1", r.URL.Path, ""
+	if match := projectPath.FindStringSubmatch(path); match != nil {
+		account = match[1]
+		prefix = "/v1/projects/" + account
+		path = "/v1" + match[2]
+	}
+
+	w.Header().Set("X-API-Schemas", f.url(prefix+"/schemas"))
+
+	switch {
+	case path == "/v1":
+		w.Write([]byte("{}"))
+	case path == "/v1/schemas":
+		schemas := []map[string]interface{}{}
+		for _, name := range []string{"service", "container", "image", "project", "apiKey"} {
+			schemas = append(schemas, map[string]interface{}{
+				"id":                name,
+				"type":              "schema",
+				"links":             map[string]string{"collection": f.url(prefix + "/" + name + "s")},
+				"collectionMethods": []string{"GET", "POST"},
+				"resourceMethods":   []string{"GET", "PUT", "DELETE"},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"type": "collection", "data": schemas})
+	case r.Method == "POST":
+		f.actions = append(f.actions, r.URL.Path)
+		if f.onAction != nil {
+			f.lock.Unlock()
+			f.onAction(r.URL.Path)
+			f.lock.Lock()
+		}
+		w.Write([]byte("{}"))
+	case f.links[r.URL.Path] != nil:
+		json.NewEncoder(w).Encode(map[string]interface{}{"type": "collection", "data": f.links[r.URL.Path]})
+	default:
+		data := []map[string]interface{}{}
+		for _, resource := range f.collections[strings.TrimPrefix(path, "/v1/")] {
+			if account == "" || resource["accountId"] == account {
+				data = append(data, resource)
+			}
+		}
+		f.list(w, r, data)
+	}
+}
+
+func (f *fakeCattle) list(w http.ResponseWriter, r *http.Request, data []map[string]interface{}) {
+	query := r.URL.Query()
+	matching := []map[string]interface{}{}
+	for _, resource := range data {
+		match := true
+		for key := range query {
+			if key == "limit" || key == "marker" || key == "removed_null" {
+				continue
+			}
+			if value, ok := resource[key]; ok && value != query.Get(key) {
+				match = false
+			}
+		}
+		if match {
+			matching = append(matching, resource)
+		}
+	}
+
+	pagination := map[string]interface{}{"partial": f.partial}
+	if f.pageSize > 0 {
+		start, _ := strconv.Atoi(query.Get("marker"))
+		end := start + f.pageSize
+		if end < len(matching) {
+			next := *r.URL
+			q := next.Query()
+			q.Set("marker", strconv.Itoa(end))
+			next.RawQuery = q.Encode()
+			pagination["next"] = f.url(next.RequestURI())
+			pagination["partial"] = true
+		} else {
+			end = len(matching)
+		}
+		matching = matching[start:end]
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":       "collection",
+		"data":       matching,
+		"pagination": pagination,
+	})
+}
+
+// set changes fields of the resource with id.
+func (f *fakeCattle) set(id string, fields map[string]interface{}) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, resources := range f.collections {
+		for _, resource := range resources {
+			if resource["id"] == id {
+				for k, v := range fields {
+					resource[k] = v
+				}
+			}
+		}
+	}
+}
+
+func (f *fakeCattle) posted() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.actions...)
+}
@@ -0,0 +1,338 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-compose-executor/uploader"
+)
+
+var contextHash = regexp.MustCompile("([0-9a-f]{64})\\.tar")
+
+// Images remembers the images generated for builds and when they were last
+// handed out. As a rancher.LaunchConfigFilter it records the image of every
+// launch config that builds. The list is kept in File.
+type Images struct {
+	File string
+
+	lock sync.Mutex
+	used map[string]time.Time
+}
+
+func NewImages(file string) *Images {
+	i := &Images{
+		File: file,
+		used: map[string]time.Time{},
+	}
+
+	if content, err := ioutil.ReadFile(file); err == nil {
+		if err := json.Unmarshal(content, &i.used); err != nil {
+			logrus.Warnf("Ignoring build image list %s: %v", file, err)
+			i.used = map[string]time.Time{}
+		}
+	}
+
+	return i
+}
+
+func (i *Images) Filter(name string, serviceConfig *project.ServiceConfig, launchConfig *client.LaunchConfig) error {
+	if launchConfig.Build == nil || launchConfig.ImageUuid == "" {
+		return nil
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.used[strings.TrimPrefix(launchConfig.ImageUuid, "docker:")] = time.Now()
+	return i.save()
+}
+
+func (i *Images) Used() map[string]time.Time {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	result := map[string]time.Time{}
+	for image, used := range i.used {
+		result[image] = used
+	}
+	return result
+}
+
+func (i *Images) Remove(image string) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	delete(i.used, image)
+	return i.save()
+}
+
+func (i *Images) save() error {
+	if i.File == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(i.used)
+	if err != nil {
+		return err
+	}
+
+	temp := i.File + ".tmp"
+	if err := ioutil.WriteFile(temp, content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, i.File)
+}
+
+// Report lists what a collection deleted, or would delete in a dry run.
+type Report struct {
+	DryRun   bool
+	Contexts []string
+	Images   []string
+	Failures []string
+}
+
+func (r *Report) String() string {
+	verb := "Deleted"
+	if r.DryRun {
+		verb = "Would delete"
+	}
+
+	msg := fmt.Sprintf("%s %d build contexts %v and %d images %v", verb, len(r.Contexts), r.Contexts, len(r.Images), r.Images)
+	if len(r.Failures) > 0 {
+		msg += fmt.Sprintf(", failed: %s", strings.Join(r.Failures, "; "))
+	}
+	return msg
+}
+
+// Collector deletes uploaded build contexts and generated images that no
+// live service refers to anymore and that were not used for Grace.
+type Collector struct {
+	Client *client.RancherClient
+	Cache  *uploader.Cache
+	Images *Images
+	Grace  time.Duration
+	DryRun bool
+}
+
+// Run does one collection.
+func (c *Collector) Run() (*Report, error) {
+	contexts, images, err := c.referenced()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		DryRun: c.DryRun,
+	}
+	cutoff := time.Now().Add(-c.Grace)
+
+	if c.Cache != nil {
+		for hash, entry := range c.Cache.Entries() {
+			if contexts[hash] || images[entry.Image] || entry.Used.After(cutoff) {
+				continue
+			}
+
+			report.Contexts = append(report.Contexts, hash)
+			if !c.DryRun {
+				if err := c.Cache.Remove(hash); err != nil {
+					report.Failures = append(report.Failures, fmt.Sprintf("context %s: %v", hash, err))
+				}
+			}
+		}
+	}
+
+	if c.Images != nil {
+		for image, used := range c.Images.Used() {
+			if images[image] || used.After(cutoff) {
+				continue
+			}
+
+			report.Images = append(report.Images, image)
+			if !c.DryRun {
+				if err := c.removeImage(image); err != nil {
+					report.Failures = append(report.Failures, fmt.Sprintf("image %s: %v", image, err))
+				}
+			}
+		}
+	}
+
+	sort.Strings(report.Contexts)
+	sort.Strings(report.Images)
+	return report, nil
+}
+
+// referenced returns the build context hashes and images in the launch
+// configs of all services that are not removed.
+func (c *Collector) referenced() (map[string]bool, map[string]bool, error) {
+	contexts := map[string]bool{}
+	images := map[string]bool{}
+
+	services, err := c.services()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, service := range services {
+		launchConfigs := []interface{}{service.LaunchConfig}
+		launchConfigs = append(launchConfigs, service.SecondaryLaunchConfigs...)
+
+		for _, launchConfig := range launchConfigs {
+			// Secondary launch configs are generic maps, so all are read as such
+			bytes, err := json.Marshal(launchConfig)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			parsed := client.LaunchConfig{}
+			if err := json.Unmarshal(bytes, &parsed); err != nil {
+				return nil, nil, err
+			}
+
+			if parsed.ImageUuid != "" {
+				images[strings.TrimPrefix(parsed.ImageUuid, "docker:")] = true
+			}
+			if parsed.Build != nil {
+				for _, match := range contextHash.FindAllStringSubmatch(parsed.Build.Context, -1) {
+					contexts[match[1]] = true
+				}
+			}
+		}
+	}
+
+	return contexts, images, nil
+}
+
+// services lists the services that are not removed in all projects. The
+// global key only sees the services of its own account, so the services of
+// each project are listed with a client scoped to it. Any project that can
+// not be listed fails the run, as its services would look unreferenced.
+func (c *Collector) services() ([]client.Service, error) {
+	projects, err := c.projects()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []client.Service{}
+	for _, project := range projects {
+		projectClient, err := client.NewRancherClient(&client.ClientOpts{
+			Url:       fmt.Sprintf("%s/projects/%s/schemas", c.Client.Opts.Url, project.Id),
+			AccessKey: c.Client.Opts.AccessKey,
+			SecretKey: c.Client.Opts.SecretKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list services of project %s: %v", project.Id, err)
+		}
+
+		services, err := projectServices(projectClient)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list services of project %s: %v", project.Id, err)
+		}
+		result = append(result, services...)
+	}
+
+	return result, nil
+}
+
+func (c *Collector) projects() ([]client.Project, error) {
+	projects, err := c.Client.Project.List(&client.ListOpts{
+		Filters: map[string]interface{}{
+			"removed_null": nil,
+			"limit":        -1,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []client.Project{}
+	for {
+		result = append(result, projects.Data...)
+
+		next := &client.ProjectCollection{}
+		ok, err := nextPage(c.Client, projects.Pagination, "Project", len(result), next)
+		if err != nil || !ok {
+			return result, err
+		}
+		projects = next
+	}
+}
+
+func projectServices(projectClient *client.RancherClient) ([]client.Service, error) {
+	services, err := projectClient.Service.List(&client.ListOpts{
+		Filters: map[string]interface{}{
+			"removed_null": nil,
+			"limit":        -1,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []client.Service{}
+	for {
+		result = append(result, services.Data...)
+
+		next := &client.ServiceCollection{}
+		ok, err := nextPage(projectClient, services.Pagination, "Service", len(result), next)
+		if err != nil || !ok {
+			return result, err
+		}
+		services = next
+	}
+}
+
+// nextPage loads the page after pagination into next and returns false if
+// there is none. A collection that is cut short without a next page is an
+// error, as anything it left out would look unreferenced.
+func nextPage(apiClient *client.RancherClient, pagination *client.Pagination, kind string, count int, next interface{}) (bool, error) {
+	if pagination == nil || pagination.Next == "" {
+		if pagination != nil && pagination.Partial {
+			return false, fmt.Errorf("%s list is incomplete after %d entries", kind, count)
+		}
+		return false, nil
+	}
+
+	page := client.Resource{
+		Links: map[string]string{"next": pagination.Next},
+	}
+	if err := apiClient.GetLink(page, "next", next); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Collector) removeImage(image string) error {
+	for _, name := range []string{image, "docker:" + image} {
+		found, err := c.Client.Image.List(&client.ListOpts{
+			Filters: map[string]interface{}{
+				"name":         name,
+				"removed_null": nil,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, existing := range found.Data {
+			if _, ok := existing.Actions["remove"]; !ok {
+				continue
+			}
+			if _, err := c.Client.Image.ActionRemove(&existing); err != nil {
+				return err
+			}
+		}
+	}
+
+	return c.Images.Remove(image)
+}
@@ -0,0 +1,262 @@
+package build
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-compose-executor/uploader"
+)
+
+func TestImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "images.json")
+	images := NewImages(file)
+
+	if err := images.Filter("db", nil, &client.LaunchConfig{ImageUuid: "docker:mysql"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := images.Filter("web", nil, &client.LaunchConfig{
+		ImageUuid: "docker:stack_web_0123456789ab",
+		Build:     &client.DockerBuild{Context: "http://builds/abc.tar"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new list reads what the first one recorded, images that are not built
+	// are not tracked
+	images = NewImages(file)
+	used := images.Used()
+	if _, ok := used["stack_web_0123456789ab"]; !ok || len(used) != 1 {
+		t.Fatal("Expected only the build image to be recorded", used)
+	}
+
+	if err := images.Remove("stack_web_0123456789ab"); err != nil {
+		t.Fatal(err)
+	}
+	if len(NewImages(file).Used()) != 0 {
+		t.Fatal("Expected removed image to be dropped from the list")
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := &Report{
+		DryRun:   true,
+		Contexts: []string{"abc"},
+		Images:   []string{"stack_web_0123456789ab"},
+	}
+
+	if s := report.String(); s != "Would delete 1 build contexts [abc] and 1 images [stack_web_0123456789ab]" {
+		t.Fatal("Unexpected report", s)
+	}
+
+	report.DryRun = false
+	report.Failures = []string{"image stack_web_0123456789ab: in use"}
+	if s := report.String(); s != "Deleted 1 build contexts [abc] and 1 images [stack_web_0123456789ab], failed: image stack_web_0123456789ab: in use" {
+		t.Fatal("Unexpected report", s)
+	}
+}
+
+// fakeUploader records the contexts deleted from it.
+type fakeUploader struct {
+	deleted []string
+}
+
+func (f *fakeUploader) Name() string {
+	return "fake"
+}
+
+func (f *fakeUploader) Upload(p *project.Project, name string, reader io.ReadSeeker, hash string) (string, string, error) {
+	return "", "", nil
+}
+
+func (f *fakeUploader) Delete(hash string) error {
+	f.deleted = append(f.deleted, hash)
+	return nil
+}
+
+var (
+	referencedContext = strings.Repeat("a", 64)
+	recentContext     = strings.Repeat("b", 64)
+	oldContext        = strings.Repeat("c", 64)
+	imageContext      = strings.Repeat("d", 64)
+)
+
+// newCollector sets up a Collector over a fake Cattle with two projects on
+// separate pages and a service in each, and a cache and image list with referenced, recently used
+// and old entries. The old ones are the only ones to collect.
+func newCollector(t *testing.T, dir string) (*Collector, *fakeCattle, *fakeUploader) {
+	old := time.Now().Add(-2 * time.Hour)
+	recent := time.Now().Add(-time.Minute)
+
+	write := func(file string, content interface{}) string {
+		bytes, err := json.Marshal(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, file), bytes, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path.Join(dir, file)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	index := write("index.json", map[string]uploader.CacheEntry{
+		referencedContext: {Image: "stack_referenced", Expires: expires, Used: old},
+		recentContext:     {Image: "stack_recent", Expires: expires, Used: recent},
+		oldContext:        {Image: "stack_old", Expires: expires, Used: old},
+		imageContext:      {Image: "stack_image", Expires: expires, Used: old},
+	})
+	images := write("images.json", map[string]time.Time{
+		"stack_image":  old,
+		"stack_recent": recent,
+		"stack_old":    old,
+	})
+
+	cattle, apiClient := newFakeCattle(t)
+	cattle.pageSize = 1
+	cattle.add("project", "1a5", map[string]interface{}{})
+	cattle.add("project", "1a6", map[string]interface{}{})
+	cattle.add("service", "1s1", map[string]interface{}{
+		"accountId": "1a5",
+		"launchConfig": map[string]interface{}{
+			"imageUuid": "docker:stack_image",
+		},
+	})
+	cattle.add("service", "1s2", map[string]interface{}{
+		"accountId": "1a6",
+		"launchConfig": map[string]interface{}{
+			"imageUuid": "docker:nginx",
+		},
+		"secondaryLaunchConfigs": []interface{}{
+			map[string]interface{}{
+				"imageUuid": "docker:stack_referenced",
+				"build": map[string]interface{}{
+					"context": "http://builds/" + referencedContext + ".tar",
+				},
+			},
+		},
+	})
+	cattle.add("image", "1i1", map[string]interface{}{
+		"name": "docker:stack_old",
+		"actions": map[string]interface{}{
+			"remove": cattle.url("/v1/images/1i1/?action=remove"),
+		},
+	})
+
+	fake := &fakeUploader{}
+	return &Collector{
+		Client: apiClient,
+		Cache:  uploader.NewCache(fake, index, time.Hour),
+		Images: NewImages(images),
+		Grace:  time.Hour,
+	}, cattle, fake
+}
+
+func TestCollectorRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	collector, cattle, fake := newCollector(t, dir)
+	defer cattle.Close()
+
+	report, err := collector.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(report.Contexts, []string{oldContext}) || !reflect.DeepEqual(report.Images, []string{"stack_old"}) || len(report.Failures) != 0 {
+		t.Fatal("Expected only the old context and image to be collected", report)
+	}
+	if !reflect.DeepEqual(fake.deleted, []string{oldContext}) {
+		t.Fatal("Expected the old context to be deleted", fake.deleted)
+	}
+	if _, ok := collector.Cache.Entries()[oldContext]; ok || len(collector.Cache.Entries()) != 3 {
+		t.Fatal("Expected the old context to be dropped from the cache", collector.Cache.Entries())
+	}
+	if _, ok := collector.Images.Used()["stack_old"]; ok || len(collector.Images.Used()) != 2 {
+		t.Fatal("Expected the old image to be dropped from the list", collector.Images.Used())
+	}
+	if posted := cattle.posted(); len(posted) != 1 || posted[0] != "/v1/images/1i1/" {
+		t.Fatal("Expected the old image to be removed", posted)
+	}
+}
+
+func TestCollectorDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	collector, cattle, fake := newCollector(t, dir)
+	defer cattle.Close()
+	collector.DryRun = true
+
+	report, err := collector.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.DryRun || !reflect.DeepEqual(report.Contexts, []string{oldContext}) || !reflect.DeepEqual(report.Images, []string{"stack_old"}) {
+		t.Fatal("Expected the old context and image to be reported", report)
+	}
+	if len(fake.deleted) != 0 || len(cattle.posted()) != 0 || len(collector.Cache.Entries()) != 4 || len(collector.Images.Used()) != 3 {
+		t.Fatal("Expected a dry run to delete nothing")
+	}
+}
+
+func TestCollectorIncompleteList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	collector, cattle, fake := newCollector(t, dir)
+	defer cattle.Close()
+	cattle.pageSize = 0
+	cattle.partial = true
+
+	if _, err := collector.Run(); err == nil {
+		t.Fatal("Expected a partial service list to fail the run")
+	}
+	if len(fake.deleted) != 0 || len(cattle.posted()) != 0 {
+		t.Fatal("Expected a failed run to delete nothing")
+	}
+}
+
+func TestCollectorProjectFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	collector, cattle, fake := newCollector(t, dir)
+	defer cattle.Close()
+	cattle.failing["/v1/projects/1a6/services"] = true
+
+	if _, err := collector.Run(); err == nil || !strings.Contains(err.Error(), "1a6") {
+		t.Fatal("Expected a project that can not be listed to fail the run", err)
+	}
+	if len(fake.deleted) != 0 || len(cattle.posted()) != 0 {
+		t.Fatal("Expected a failed run to delete nothing")
+	}
+}
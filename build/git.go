@@ -0,0 +1,307 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/rancher-compose-executor/stack"
+)
+
+const (
+	CommitLabel = "io.rancher.build.git.commit"
+
+	// GitTimeout bounds every git command, so an unresponsive server can not
+	// hold up the create.
+	GitTimeout = 10 * time.Minute
+)
+
+// askPass answers git's username and password prompts from the environment
+// so credentials never show up in arguments or URLs.
+const askPass = `#!/bin/sh
+case "$1" in
+Username*) echo "$GIT_USERNAME" ;;
+*) echo "$GIT_PASSWORD" ;;
+esac
+`
+
+// GitContext is a build context in a git repository, written like docker
+// does as <repository>#<ref>:<subdirectory>.
+type GitContext struct {
+	Repository string
+	Host       string
+	Ref        string
+	Subdir     string
+}
+
+// ParseGitContext returns the git context of build, or false if build is not
+// a git repository.
+func ParseGitContext(build string) (GitContext, bool) {
+	repository, fragment := build, ""
+	if i := strings.Index(build, "#"); i >= 0 {
+		repository, fragment = build[:i], build[i+1:]
+	}
+
+	context := GitContext{
+		Repository: repository,
+	}
+
+	switch {
+	case strings.HasPrefix(repository, "git@"):
+		context.Host = strings.SplitN(strings.TrimPrefix(repository, "git@"), ":", 2)[0]
+	case strings.HasPrefix(repository, "github.com/"):
+		context.Repository = "https://" + repository
+		context.Host = "github.com"
+	case strings.HasPrefix(repository, "git://"), strings.HasPrefix(repository, "ssh://"),
+		(strings.HasPrefix(repository, "http://") || strings.HasPrefix(repository, "https://")) && strings.HasSuffix(repository, ".git"):
+		u, err := url.Parse(repository)
+		if err != nil {
+			return context, false
+		}
+		context.Host = u.Host
+		if i := strings.Index(context.Host, "@"); i >= 0 {
+			context.Host = context.Host[i+1:]
+		}
+	default:
+		return context, false
+	}
+
+	parts := strings.SplitN(fragment, ":", 2)
+	context.Ref = parts[0]
+	if len(parts) == 2 {
+		context.Subdir = parts[1]
+	}
+
+	return context, true
+}
+
+// FetchGitContexts clones every git build context in configs into a temporary
+// directory and points the service's build at the checkout, so it goes
+// through the same archive and upload path as a local build. The commit that
+// was checked out is recorded in the CommitLabel label. The returned function
+// removes the checkouts.
+func FetchGitContexts(configs map[string]*project.ServiceConfig, credentials []stack.GitCredential) (func(), error) {
+	names := []string{}
+	for name, config := range configs {
+		if _, ok := ParseGitContext(config.Build); ok {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return func() {}, nil
+	}
+	sort.Strings(names)
+
+	dir, err := ioutil.TempDir("", "git-contexts")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	checkouts := map[string]string{}
+	commits := map[string]string{}
+
+	for _, name := range names {
+		config := configs[name]
+		context, _ := ParseGitContext(config.Build)
+
+		key := context.Repository + "#" + context.Ref
+		checkout, ok := checkouts[key]
+		if !ok {
+			checkout = filepath.Join(dir, strconv.Itoa(len(checkouts)))
+			commit, err := fetch(context, checkout, findCredential(credentials, context.Host))
+			if err != nil {
+				cleanup()
+				return nil, fmt.Errorf("Failed to fetch build context of %s from %s: %v", name, context.Repository, err)
+			}
+			checkouts[key] = checkout
+			commits[key] = commit
+		}
+
+		root, err := subdir(checkout, context.Subdir)
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("Build context of %s: %v", name, err)
+		}
+
+		logrus.Infof("Building %s from %s at %s", name, context.Repository, commits[key])
+
+		config.Build = root
+		labels := config.Labels.MapParts()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[CommitLabel] = commits[key]
+		config.Labels = project.NewSliceorMap(labels)
+	}
+
+	return cleanup, nil
+}
+
+func findCredential(credentials []stack.GitCredential, host string) *stack.GitCredential {
+	for i := range credentials {
+		if credentials[i].Host == host {
+			return &credentials[i]
+		}
+	}
+	return nil
+}
+
+// subdir returns dir inside checkout with all symlinks resolved, so a link in
+// the repository can not point the build context at files of the host.
+func subdir(checkout, dir string) (string, error) {
+	resolvedCheckout, err := filepath.EvalSymlinks(checkout)
+	if err != nil {
+		return "", err
+	}
+
+	root, err := filepath.EvalSymlinks(filepath.Join(checkout, dir))
+	if err != nil {
+		return "", fmt.Errorf("subdirectory %s not found", dir)
+	}
+
+	if rel, err := filepath.Rel(resolvedCheckout, root); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("subdirectory %s is outside of the repository", dir)
+	}
+
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("subdirectory %s not found", dir)
+	}
+
+	return root, nil
+}
+
+// fetch clones the repository into dest, checks out the ref and returns the
+// commit. File times are set to the commit time so an unchanged commit
+// produces the same archive.
+func fetch(context GitContext, dest string, credential *stack.GitCredential) (string, error) {
+	env, cleanup, err := gitEnv(credential)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	ref := context.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid ref %s", ref)
+	}
+
+	if _, err := git(env, "", "clone", "--quiet", "--no-checkout", "--", context.Repository, dest); err != nil {
+		return "", err
+	}
+
+	if _, err := git(env, dest, "checkout", "--quiet", ref, "--"); err != nil {
+		return "", err
+	}
+
+	commit, err := git(env, dest, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	timestamp, err := git(env, dest, "log", "-1", "--format=%ct")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.RemoveAll(filepath.Join(dest, ".git")); err != nil {
+		return "", err
+	}
+
+	if seconds, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+		commitTime := time.Unix(seconds, 0)
+		filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode()&os.ModeSymlink == 0 {
+				os.Chtimes(path, commitTime, commitTime)
+			}
+			return nil
+		})
+	}
+
+	return commit, nil
+}
+
+func gitEnv(credential *stack.GitCredential) ([]string, func(), error) {
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if credential == nil {
+		return env, func() {}, nil
+	}
+
+	dir, err := ioutil.TempDir("", "git-credentials")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	if credential.SSHKey != "" {
+		key := filepath.Join(dir, "id")
+		if err := ioutil.WriteFile(key, []byte(strings.TrimSpace(credential.SSHKey)+"\n"), 0600); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		knownHosts := filepath.Join(dir, "known_hosts")
+		if err := ioutil.WriteFile(knownHosts, []byte(strings.TrimSpace(credential.KnownHosts)+"\n"), 0600); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o StrictHostKeyChecking=yes -o UserKnownHostsFile=%s", key, knownHosts))
+	} else {
+		script := filepath.Join(dir, "askpass")
+		if err := ioutil.WriteFile(script, []byte(askPass), 0700); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		env = append(env, "GIT_ASKPASS="+script, "GIT_USERNAME="+credential.Username, "GIT_PASSWORD="+credential.Password)
+	}
+
+	return env, cleanup, nil
+}
+
+func git(env []string, dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Env = env
+	cmd.Dir = dir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Own process group so a timeout also kills ssh and other helpers
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+
+	timer := time.AfterFunc(GitTimeout, func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+
+	err := cmd.Wait()
+	if !timer.Stop() {
+		return "", fmt.Errorf("git %s timed out after %s", args[0], GitTimeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
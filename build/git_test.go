@@ -0,0 +1,102 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseGitContext(t *testing.T) {
+	for build, expected := range map[string]GitContext{
+		"https://github.com/org/repo.git#v1.0:docker": {"https://github.com/org/repo.git", "github.com", "v1.0", "docker"},
+		"git@gitlab.example.com:org/repo.git#main":    {"git@gitlab.example.com:org/repo.git", "gitlab.example.com", "main", ""},
+		"github.com/org/repo#:app":                    {"https://github.com/org/repo", "github.com", "", "app"},
+		"ssh://git@git.example.com:2222/repo.git":     {"ssh://git@git.example.com:2222/repo.git", "git.example.com:2222", "", ""},
+	} {
+		context, ok := ParseGitContext(build)
+		if !ok || context != expected {
+			t.Errorf("Unexpected context for %s: %#v", build, context)
+		}
+	}
+
+	for _, build := range []string{".", "./app", "https://example.com/context.tar.gz"} {
+		if _, ok := ParseGitContext(build); ok {
+			t.Errorf("Expected %s not to be a git context", build)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	env, _, _ := gitEnv(nil)
+	run := func(args ...string) string {
+		out, err := git(env, repo, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	if err := os.MkdirAll(filepath.Join(repo, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo, "app", "Dockerfile"), []byte("FROM busybox\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("init", "--quiet")
+	run("add", ".")
+	run("commit", "--quiet", "-m", "first")
+	run("tag", "v1")
+	first := run("rev-parse", "HEAD")
+
+	if err := ioutil.WriteFile(filepath.Join(repo, "app", "Dockerfile"), []byte("FROM alpine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("commit", "--quiet", "-am", "second")
+
+	dest := filepath.Join(dir, "checkout")
+	commit, err := fetch(GitContext{Repository: repo, Ref: "v1"}, dest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if commit != first {
+		t.Fatal("Expected commit", first, "got", commit)
+	}
+
+	root, err := subdir(dest, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(root, "Dockerfile"))
+	if err != nil || string(content) != "FROM busybox\n" {
+		t.Fatal("Unexpected Dockerfile", string(content), err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, ".git")); !os.IsNotExist(err) {
+		t.Fatal("Expected .git to be removed")
+	}
+
+	if _, err := subdir(dest, "../repo"); err == nil {
+		t.Fatal("Expected subdirectory outside of the repository to fail")
+	}
+
+	if err := os.Symlink("/etc", filepath.Join(dest, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := subdir(dest, "etc"); err == nil {
+		t.Fatal("Expected subdirectory linking outside of the repository to fail")
+	}
+
+	if _, err := fetch(GitContext{Repository: repo, Ref: "--orphan=x"}, filepath.Join(dir, "option"), nil); err == nil {
+		t.Fatal("Expected ref starting with - to fail")
+	}
+}
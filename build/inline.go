@@ -0,0 +1,139 @@
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/rancher-compose-executor/stack"
+)
+
+const (
+	DefaultInlineMaxBytes = 1 << 20
+	DefaultInlineMaxFiles = 100
+)
+
+// inlineTime is the modification time of all inline files, so identical
+// inline builds produce identical archives.
+var inlineTime = time.Unix(0, 0)
+
+// WriteInlineContexts writes the inline build of every service into a
+// temporary directory and points the service's build at it, so it goes
+// through the same archive and upload path as a local build. Paths must stay
+// inside the context and all files of a stack together may not exceed
+// maxBytes or number more than maxFiles. The returned function removes the
+// directories.
+func WriteInlineContexts(configs map[string]*project.ServiceConfig, builds map[string]stack.InlineBuild, maxBytes int64, maxFiles int) (func(), error) {
+	if len(builds) == 0 {
+		return func() {}, nil
+	}
+
+	if maxBytes <= 0 {
+		maxBytes = DefaultInlineMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultInlineMaxFiles
+	}
+
+	names := []string{}
+	for name := range builds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := map[string]map[string]string{}
+	total := int64(0)
+	count := 0
+
+	for _, name := range names {
+		if _, ok := configs[name]; !ok {
+			return nil, fmt.Errorf("Inline build for %s does not match a service", name)
+		}
+
+		inline := builds[name]
+		if inline.Dockerfile == "" {
+			return nil, fmt.Errorf("Inline build for %s has no dockerfile", name)
+		}
+
+		files[name] = map[string]string{}
+		for file, content := range inline.Files {
+			clean, err := cleanPath(file)
+			if err != nil {
+				return nil, fmt.Errorf("Inline build for %s: %v", name, err)
+			}
+			if clean == "Dockerfile" {
+				return nil, fmt.Errorf("Inline build for %s: use dockerfile instead of a Dockerfile file", name)
+			}
+			files[name][clean] = content
+			total += int64(len(content))
+		}
+		files[name]["Dockerfile"] = inline.Dockerfile
+		total += int64(len(inline.Dockerfile))
+		count += len(files[name])
+	}
+
+	if total > maxBytes {
+		return nil, fmt.Errorf("Inline builds are %d bytes which exceeds the limit of %d", total, maxBytes)
+	}
+	if count > maxFiles {
+		return nil, fmt.Errorf("Inline builds have %d files which exceeds the limit of %d", count, maxFiles)
+	}
+
+	dir, err := ioutil.TempDir("", "inline-contexts")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	for i, name := range names {
+		// Service names are not validated, so they are kept out of the path
+		root := filepath.Join(dir, strconv.Itoa(i))
+		for file, content := range files[name] {
+			target := filepath.Join(root, filepath.FromSlash(file))
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				cleanup()
+				return nil, err
+			}
+			if err := ioutil.WriteFile(target, []byte(content), 0644); err != nil {
+				cleanup()
+				return nil, err
+			}
+		}
+
+		if err := setTimes(root); err != nil {
+			cleanup()
+			return nil, err
+		}
+
+		configs[name].Build = root
+		configs[name].Dockerfile = ""
+	}
+
+	return cleanup, nil
+}
+
+func setTimes(root string) error {
+	return filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(file, inlineTime, inlineTime)
+	})
+}
+
+func cleanPath(file string) (string, error) {
+	clean := path.Clean(strings.Replace(file, "\\", "/", -1))
+	if file == "" || path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("file %q must be a relative path inside the build context", file)
+	}
+	return clean, nil
+}
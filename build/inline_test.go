@@ -0,0 +1,91 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/rancher-compose-executor/stack"
+)
+
+func TestWriteInlineContexts(t *testing.T) {
+	configs := map[string]*project.ServiceConfig{
+		"web": {Build: ".", Dockerfile: "Dockerfile.dev"},
+		"db":  {Image: "redis"},
+	}
+
+	cleanup, err := WriteInlineContexts(configs, map[string]stack.InlineBuild{
+		"web": {
+			Dockerfile: "FROM busybox\nCOPY bin/run.sh /\n",
+			Files: map[string]string{
+				"bin/../bin/run.sh": "echo hi\n",
+			},
+		},
+	}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	if configs["web"].Dockerfile != "" || configs["db"].Build != "" {
+		t.Fatal("Unexpected configs", configs["web"], configs["db"])
+	}
+
+	for file, expected := range map[string]string{
+		"Dockerfile": "FROM busybox\nCOPY bin/run.sh /\n",
+		"bin/run.sh": "echo hi\n",
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(configs["web"].Build, file))
+		if err != nil || string(content) != expected {
+			t.Fatal("Unexpected", file, string(content), err)
+		}
+
+		// Identical inline builds must produce identical archives
+		info, err := os.Stat(filepath.Join(configs["web"].Build, file))
+		if err != nil || !info.ModTime().Equal(inlineTime) {
+			t.Fatal("Unexpected modification time", file, info, err)
+		}
+	}
+}
+
+func TestWriteInlineContextsRejected(t *testing.T) {
+	configs := map[string]*project.ServiceConfig{
+		"web": {},
+	}
+
+	for msg, builds := range map[string]map[string]stack.InlineBuild{
+		"inside the build context": {"web": {Dockerfile: "FROM busybox", Files: map[string]string{"../etc/passwd": ""}}},
+		"relative path":            {"web": {Dockerfile: "FROM busybox", Files: map[string]string{"/etc/passwd": ""}}},
+		"exceeds the limit of 16":  {"web": {Dockerfile: "FROM busybox", Files: map[string]string{"big": "0123456789"}}},
+		"does not match a service": {"other": {Dockerfile: "FROM busybox"}},
+		"has no dockerfile":        {"web": {}},
+		"2 files which exceeds":    {"web": {Dockerfile: "FROM busybox", Files: map[string]string{"a": ""}}},
+	} {
+		_, err := WriteInlineContexts(configs, builds, 16, 1)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Error("Expected error containing", msg, "got", err)
+		}
+	}
+}
+
+func TestWriteInlineContextsServiceName(t *testing.T) {
+	name := "../../escape"
+	configs := map[string]*project.ServiceConfig{
+		name: {Build: "."},
+	}
+
+	cleanup, err := WriteInlineContexts(configs, map[string]stack.InlineBuild{
+		name: {Dockerfile: "FROM busybox\n"},
+	}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	if strings.Contains(configs[name].Build, "escape") {
+		t.Fatal("Expected service name to stay out of the context path", configs[name].Build)
+	}
+}
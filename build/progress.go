@@ -0,0 +1,289 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	DefaultProgressLines = 20
+	DefaultBuildTimeout  = 30 * time.Minute
+)
+
+// Progress follows the containers of the services that build an image while
+// the stack is being created and, with Follow, until their builds are done.
+// Every new transitioning message of such a container is passed to Publish
+// and the last Lines messages of each service are kept to explain a failed
+// build. A build counts as failed when its service ends up in error, errors
+// of single containers are retried by Cattle and do not fail it.
+type Progress struct {
+	Client        *client.RancherClient
+	EnvironmentId string
+	Services      []string
+	Lines         int
+	Interval      time.Duration
+	Timeout       time.Duration
+	Publish       func(msg string)
+
+	lock    sync.Mutex
+	logs    map[string][]string
+	last    map[string]string
+	failed  map[string]bool
+	settled map[string]bool
+	done    chan bool
+	wg      sync.WaitGroup
+}
+
+// NewProgress returns a Progress for the services in configs that build. A
+// sidekick is built in the containers of its primary service, so the primary
+// is followed instead.
+func NewProgress(apiClient *client.RancherClient, environmentId string, configs map[string]*project.ServiceConfig, publish func(string)) *Progress {
+	primaries := primaryServices(configs)
+
+	services := []string{}
+	for name, config := range configs {
+		if config.Build == "" {
+			continue
+		}
+		if primary, ok := primaries[name]; ok {
+			name = primary
+		}
+		if !contains(services, name) {
+			services = append(services, name)
+		}
+	}
+	sort.Strings(services)
+
+	return &Progress{
+		Client:        apiClient,
+		EnvironmentId: environmentId,
+		Services:      services,
+		Lines:         DefaultProgressLines,
+		Interval:      time.Second,
+		Timeout:       DefaultBuildTimeout,
+		Publish:       publish,
+		logs:          map[string][]string{},
+		last:          map[string]string{},
+		failed:        map[string]bool{},
+		settled:       map[string]bool{},
+	}
+}
+
+func (p *Progress) Start() {
+	if len(p.Services) == 0 {
+		return
+	}
+
+	p.done = make(chan bool)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			select {
+			case <-p.done:
+				p.poll()
+				return
+			case <-time.After(p.Interval):
+				p.poll()
+			}
+		}
+	}()
+}
+
+// Stop polls one last time and waits for the poller to finish.
+func (p *Progress) Stop() {
+	if p.done == nil {
+		return
+	}
+	close(p.done)
+	p.wg.Wait()
+}
+
+// Follow polls until the services that build are done activating, so builds
+// that are already running can still fail the create. Nothing is activated
+// here, services that are not being activated are done right away.
+func (p *Progress) Follow() error {
+	if len(p.Services) == 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(p.Timeout)
+	for !p.poll() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Builds did not finish within %s", p.Timeout)
+		}
+		time.Sleep(p.Interval)
+	}
+
+	return nil
+}
+
+// poll records the state of the services that build and the messages of
+// their containers. It returns true once every service is settled.
+func (p *Progress) poll() bool {
+	services, err := p.list()
+	if err != nil {
+		logrus.Debugf("Failed to list services to follow builds: %v", err)
+		return false
+	}
+
+	for _, service := range services {
+		p.recordService(service)
+
+		var instances client.ContainerCollection
+		if err := p.Client.GetLink(service.Resource, "instances", &instances); err != nil {
+			logrus.Debugf("Failed to list instances of %s to follow builds: %v", service.Name, err)
+			continue
+		}
+
+		for _, instance := range instances.Data {
+			p.record(service.Name, instance)
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, service := range p.Services {
+		// A service that does not exist has nothing left to build
+		if !p.settled[service] && contains(names(services), service) {
+			return false
+		}
+	}
+	return true
+}
+
+// list returns the services of the stack that build.
+func (p *Progress) list() ([]client.Service, error) {
+	services, err := p.Client.Service.List(&client.ListOpts{
+		Filters: map[string]interface{}{
+			"environmentId": p.EnvironmentId,
+			"removed_null":  nil,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []client.Service{}
+	for _, service := range services.Data {
+		if contains(p.Services, service.Name) {
+			result = append(result, service)
+		}
+	}
+	return result, nil
+}
+
+func (p *Progress) recordService(service client.Service) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.settled[service.Name] = service.Transitioning != "yes"
+	p.failed[service.Name] = service.Transitioning == "error" || service.State == "error"
+}
+
+func (p *Progress) record(service string, instance client.Container) {
+	msg := strings.TrimSpace(instance.TransitioningMessage)
+	if msg == "" {
+		return
+	}
+
+	p.lock.Lock()
+	if p.last[instance.Id] == msg {
+		p.lock.Unlock()
+		return
+	}
+	p.last[instance.Id] = msg
+
+	lines := append(p.logs[service], msg)
+	if len(lines) > p.Lines {
+		lines = lines[len(lines)-p.Lines:]
+	}
+	p.logs[service] = lines
+	p.lock.Unlock()
+
+	if p.Publish != nil {
+		p.Publish(fmt.Sprintf("Building %s: %s", service, msg))
+	}
+}
+
+// Tail returns the last messages recorded for service.
+func (p *Progress) Tail(service string) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string{}, p.logs[service]...)
+}
+
+// Error adds the recorded build output to err. Without an err it returns an
+// error only if a service that builds ended up in error.
+func (p *Progress) Error(err error) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	services := []string{}
+	for _, service := range p.Services {
+		if len(p.logs[service]) > 0 && (err != nil || p.failed[service]) {
+			services = append(services, service)
+		}
+	}
+
+	if err == nil {
+		failed := false
+		for _, service := range p.Services {
+			failed = failed || p.failed[service]
+		}
+		if !failed {
+			return nil
+		}
+		err = errors.New("Build failed")
+	}
+
+	if len(services) == 0 {
+		return err
+	}
+
+	msg := err.Error()
+	for _, service := range services {
+		msg += fmt.Sprintf("\nBuild output of %s:\n  %s", service, strings.Join(p.logs[service], "\n  "))
+	}
+
+	return errors.New(msg)
+}
+
+// primaryServices maps every sidekick in configs to the service that lists
+// it in io.rancher.sidekicks.
+func primaryServices(configs map[string]*project.ServiceConfig) map[string]string {
+	result := map[string]string{}
+	for primary, config := range configs {
+		for _, name := range strings.Split(config.Labels.MapParts()["io.rancher.sidekicks"], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				result[name] = primary
+			}
+		}
+	}
+	return result
+}
+
+func names(services []client.Service) []string {
+	result := []string{}
+	for _, service := range services {
+		result = append(result, service.Name)
+	}
+	return result
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
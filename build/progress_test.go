@@ -0,0 +1,166 @@
+package build
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/go-rancher/client"
+)
+
+func TestProgress(t *testing.T) {
+	published := []string{}
+	progress := NewProgress(nil, "1e1", map[string]*project.ServiceConfig{
+		"web": {Build: "/tmp/web"},
+		"db":  {Image: "redis"},
+	}, func(msg string) {
+		published = append(published, msg)
+	})
+	progress.Lines = 2
+
+	if len(progress.Services) != 1 || progress.Services[0] != "web" {
+		t.Fatal("Expected to follow web only", progress.Services)
+	}
+
+	for _, msg := range []string{"Step 1 : FROM busybox", "Step 1 : FROM busybox", "Step 2 : RUN make", "Step 3 : COPY . /"} {
+		progress.record("web", client.Container{Resource: client.Resource{Id: "1i1"}, TransitioningMessage: msg})
+	}
+
+	if len(published) != 3 || published[0] != "Building web: Step 1 : FROM busybox" {
+		t.Fatal("Unexpected messages", published)
+	}
+
+	if tail := progress.Tail("web"); len(tail) != 2 || tail[0] != "Step 2 : RUN make" {
+		t.Fatal("Unexpected tail", tail)
+	}
+
+	if err := progress.Error(nil); err != nil {
+		t.Fatal("Expected no error", err)
+	}
+
+	err := progress.Error(errors.New("Service web failed"))
+	if err == nil || !strings.Contains(err.Error(), "Service web failed\nBuild output of web:\n  Step 2 : RUN make\n  Step 3 : COPY . /") {
+		t.Fatal("Unexpected error", err)
+	}
+
+	// A failed container alone is rescheduled by Cattle and fails nothing
+	progress.record("web", client.Container{Resource: client.Resource{Id: "1i1"}, Transitioning: "error", TransitioningMessage: "make: not found"})
+	if err := progress.Error(nil); err != nil {
+		t.Fatal("Expected no error for a failed container", err)
+	}
+
+	progress.recordService(client.Service{Name: "web", State: "active", Transitioning: "error"})
+	if err := progress.Error(nil); err == nil || !strings.Contains(err.Error(), "Build failed\nBuild output of web:\n  Step 3 : COPY . /\n  make: not found") {
+		t.Fatal("Unexpected error", err)
+	}
+}
+
+func TestProgressFollow(t *testing.T) {
+	for _, final := range []string{"no", "error"} {
+		cattle, apiClient := newFakeCattle(t)
+
+		// web is started on create, db is left inactive
+		cattle.add("service", "1s1", map[string]interface{}{
+			"name":          "web",
+			"environmentId": "1e1",
+			"state":         "activating",
+			"transitioning": "yes",
+			"links":         map[string]interface{}{"instances": cattle.url("/v1/services/1s1/instances")},
+		})
+		cattle.add("service", "1s2", map[string]interface{}{
+			"name":          "db",
+			"environmentId": "1e1",
+			"state":         "inactive",
+			"transitioning": "no",
+			"actions":       map[string]interface{}{"activate": cattle.url("/v1/services/1s2/?action=activate")},
+		})
+		cattle.links["/v1/services/1s1/instances"] = []map[string]interface{}{
+			{"id": "1i1", "transitioningMessage": "Step 1 : FROM busybox"},
+		}
+
+		published := []string{}
+		progress := NewProgress(apiClient, "1e1", map[string]*project.ServiceConfig{
+			"web": {Build: "/tmp/web"},
+			"db":  {Build: "/tmp/db"},
+		}, func(msg string) {
+			published = append(published, msg)
+			// The build finishes after its first message was seen
+			cattle.set("1s1", map[string]interface{}{"state": "active", "transitioning": final})
+		})
+		progress.Interval = time.Millisecond
+
+		if err := progress.Follow(); err != nil {
+			t.Fatal(err)
+		}
+
+		if posted := cattle.posted(); len(posted) != 0 {
+			t.Fatal("Expected nothing to be activated", posted)
+		}
+		if len(published) != 1 || published[0] != "Building web: Step 1 : FROM busybox" {
+			t.Fatal("Unexpected messages", published)
+		}
+
+		err := progress.Error(nil)
+		if final == "no" && err != nil {
+			t.Fatal("Expected build to succeed", err)
+		}
+		if final == "error" && (err == nil || !strings.Contains(err.Error(), "Build failed\nBuild output of web:\n  Step 1 : FROM busybox")) {
+			t.Fatal("Expected build to fail", err)
+		}
+
+		cattle.Close()
+	}
+}
+
+func TestProgressFollowSidekick(t *testing.T) {
+	cattle, apiClient := newFakeCattle(t)
+	defer cattle.Close()
+
+	cattle.add("service", "1s1", map[string]interface{}{
+		"name":          "web",
+		"environmentId": "1e1",
+		"state":         "active",
+		"transitioning": "no",
+	})
+
+	progress := NewProgress(apiClient, "1e1", map[string]*project.ServiceConfig{
+		"web": {
+			Image:  "nginx",
+			Labels: project.NewSliceorMap(map[string]string{"io.rancher.sidekicks": "helper"}),
+		},
+		"helper": {Build: "/tmp/helper"},
+	}, nil)
+	progress.Interval = time.Millisecond
+	progress.Timeout = time.Second
+
+	if len(progress.Services) != 1 || progress.Services[0] != "web" {
+		t.Fatal("Expected to follow the primary of the sidekick", progress.Services)
+	}
+	if err := progress.Follow(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProgressFollowTimeout(t *testing.T) {
+	cattle, apiClient := newFakeCattle(t)
+	defer cattle.Close()
+
+	cattle.add("service", "1s1", map[string]interface{}{
+		"name":          "web",
+		"environmentId": "1e1",
+		"state":         "activating",
+		"transitioning": "yes",
+	})
+
+	progress := NewProgress(apiClient, "1e1", map[string]*project.ServiceConfig{
+		"web": {Build: "/tmp/web"},
+	}, nil)
+	progress.Interval = time.Millisecond
+	progress.Timeout = 10 * time.Millisecond
+
+	if err := progress.Follow(); err == nil || !strings.Contains(err.Error(), "did not finish") {
+		t.Fatal("Expected timeout", err)
+	}
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rancher/rancher-compose-executor/secrets"
+	"github.com/rancher/rancher-compose-executor/uploader"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DefaultPriority = 2000
+	DefaultWorkers  = 10
+
+	DefaultBuildsDir    = "/var/lib/rancher-compose-executor/builds"
+	DefaultBuildsListen = ":8090"
+	DefaultGCInterval   = time.Hour
+	DefaultGCGrace      = 7 * 24 * time.Hour
+
+	FeatureTemplates    = "templates"
+	FeatureProjectKeys  = "project_keys"
+	FeaturePrePull      = "pre_pull"
+	FeatureFollowBuilds = "follow_builds"
+	FeatureBuilds       = "builds"
+)
+
+// Config holds the executor settings. Anything not set in the config file
+// falls back to the CATTLE_* and POLICY_FILE environment variables.
+type Config struct {
+	Url        string          `yaml:"url,omitempty"`
+	AccessKey  string          `yaml:"access_key,omitempty"`
+	SecretKey  string          `yaml:"secret_key,omitempty"`
+	Priority   int             `yaml:"priority,omitempty"`
+	Workers    int             `yaml:"workers,omitempty"`
+	PolicyFile string          `yaml:"policy_file,omitempty"`
+	Features   map[string]bool `yaml:"features,omitempty"`
+	Signing    Signing         `yaml:"signing,omitempty"`
+	Secrets    secrets.Config  `yaml:"secrets,omitempty"`
+	Builds     Builds          `yaml:"builds,omitempty"`
+}
+
+// Builds configures where build contexts are stored and served from. With an
+// S3 bucket set contexts go to S3, otherwise they are stored in Dir and served
+// on Listen, with Url being the address agents use to reach it.
+// InlineMaxBytes and InlineMaxFiles limit the size and number of the inline
+// build files of a stack. Only the inline limits and the GC settings other
+// than the interval apply on reload, the rest is read once at startup.
+// Builds are set up only if the builds feature is switched on or, without the
+// toggle, if they are Configured.
+type Builds struct {
+	Dir        string            `yaml:"dir,omitempty"`
+	Listen     string            `yaml:"listen,omitempty"`
+	Url        string            `yaml:"url,omitempty"`
+	SigningKey string            `yaml:"signing_key,omitempty"`
+	TTL        time.Duration     `yaml:"ttl,omitempty"`
+	S3         uploader.S3Config `yaml:"s3,omitempty"`
+
+	InlineMaxBytes int64 `yaml:"inline_max_bytes,omitempty"`
+	InlineMaxFiles int   `yaml:"inline_max_files,omitempty"`
+
+	GC GC `yaml:"gc,omitempty"`
+}
+
+// Configured returns true if a bucket, URL or signing key is set, which only
+// makes sense with builds.
+func (b Builds) Configured() bool {
+	return b.S3.Bucket != "" || b.Url != "" || b.SigningKey != ""
+}
+
+// GC configures the garbage collection of build contexts and images that no
+// service uses anymore. With DryRun set it only reports what it would delete.
+type GC struct {
+	Disabled bool          `yaml:"disabled,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	Grace    time.Duration `yaml:"grace,omitempty"`
+	DryRun   bool          `yaml:"dry_run,omitempty"`
+}
+
+// Signing lists the accounts whose stacks must carry a valid signature by one
+// of the keys in TrustedKeys. An account of "*" enforces it for all accounts.
+type Signing struct {
+	TrustedKeys string   `yaml:"trusted_keys,omitempty"`
+	Accounts    []string `yaml:"accounts,omitempty"`
+}
+
+func (s Signing) Enforced(accountId string) bool {
+	for _, account := range s.Accounts {
+		if account == "*" || account == accountId {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	current *Config
+	lock    sync.RWMutex
+)
+
+// Current returns the config in effect. Without a config file this is the
+// config built from the environment.
+func Current() *Config {
+	lock.RLock()
+	c := current
+	lock.RUnlock()
+
+	if c == nil {
+		c = FromEnv()
+	}
+	return c
+}
+
+func set(c *Config) {
+	lock.Lock()
+	current = c
+	lock.Unlock()
+}
+
+func FromEnv() *Config {
+	c := &Config{}
+	c.setDefaults()
+	return c
+}
+
+// Load reads file and fills in everything it does not set.
+func Load(file string) (*Config, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := yaml.Unmarshal(bytes, c); err != nil {
+		return nil, fmt.Errorf("Failed to parse config %s: %v", file, err)
+	}
+
+	c.setDefaults()
+	return c, nil
+}
+
+func (c *Config) setDefaults() {
+	if c.Url == "" {
+		c.Url = os.Getenv("CATTLE_URL")
+	}
+	if c.AccessKey == "" {
+		c.AccessKey = os.Getenv("CATTLE_ACCESS_KEY")
+	}
+	if c.SecretKey == "" {
+		c.SecretKey = os.Getenv("CATTLE_SECRET_KEY")
+	}
+	if c.PolicyFile == "" {
+		c.PolicyFile = os.Getenv("POLICY_FILE")
+	}
+	if c.Priority == 0 {
+		c.Priority = DefaultPriority
+	}
+	if c.Workers == 0 {
+		c.Workers = DefaultWorkers
+	}
+	if c.Builds.Dir == "" {
+		c.Builds.Dir = DefaultBuildsDir
+	}
+	if c.Builds.Listen == "" {
+		c.Builds.Listen = DefaultBuildsListen
+	}
+	if c.Builds.Url == "" {
+		c.Builds.Url = os.Getenv("BUILDS_URL")
+	}
+	if c.Builds.GC.Interval == 0 {
+		c.Builds.GC.Interval = DefaultGCInterval
+	}
+	if c.Builds.GC.Grace == 0 {
+		c.Builds.GC.Grace = DefaultGCGrace
+	}
+}
+
+// Enabled returns the toggle for feature, or def if the config does not set it.
+func (c *Config) Enabled(feature string, def bool) bool {
+	if v, ok := c.Features[feature]; ok {
+		return v
+	}
+	return def
+}
+
+// SameSubscription returns true if both configs subscribe to events the same
+// way, so the event router does not need to be restarted.
+func (c *Config) SameSubscription(other *Config) bool {
+	return c.Url == other.Url &&
+		c.AccessKey == other.AccessKey &&
+		c.SecretKey == other.SecretKey &&
+		c.Priority == other.Priority &&
+		c.Workers == other.Workers
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rancher/rancher-compose-executor/uploader"
+)
+
+func writeConfig(t *testing.T, f *os.File, content string) {
+	if err := ioutil.WriteFile(f.Name(), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	os.Setenv("CATTLE_URL", "http://env")
+	os.Setenv("CATTLE_ACCESS_KEY", "envaccess")
+	defer os.Unsetenv("CATTLE_URL")
+	defer os.Unsetenv("CATTLE_ACCESS_KEY")
+
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	writeConfig(t, f, `
+access_key: fileaccess
+workers: 3
+features:
+  templates: false
+`)
+
+	c, err := Load(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Url != "http://env" || c.AccessKey != "fileaccess" {
+		t.Fatalf("Unexpected credentials: %s %s", c.Url, c.AccessKey)
+	}
+	if c.Workers != 3 || c.Priority != DefaultPriority {
+		t.Fatalf("Unexpected workers %d priority %d", c.Workers, c.Priority)
+	}
+	if c.Enabled(FeatureTemplates, true) || !c.Enabled(FeatureProjectKeys, true) {
+		t.Fatal("Unexpected feature toggles")
+	}
+}
+
+func TestBuildsConfigured(t *testing.T) {
+	os.Unsetenv("BUILDS_URL")
+	if FromEnv().Builds.Configured() {
+		t.Fatal("Expected the defaults alone not to configure builds")
+	}
+
+	for _, builds := range []Builds{{Url: "http://builds"}, {SigningKey: "key"}, {S3: uploader.S3Config{Bucket: "builds"}}} {
+		if !builds.Configured() {
+			t.Fatal("Expected builds to be configured", builds)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	defer set(nil)
+
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	writeConfig(t, f, "access_key: one\n")
+
+	changes, err := Watch(f.Name(), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Current().AccessKey != "one" {
+		t.Fatalf("Unexpected access key %s", Current().AccessKey)
+	}
+
+	writeConfig(t, f, "access_key: [")
+	writeConfig(t, f, "access_key: two\n")
+
+	select {
+	case c := <-changes:
+		if c.AccessKey != "two" || Current().AccessKey != "two" {
+			t.Fatalf("Unexpected access key %s", c.AccessKey)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Config change not seen")
+	}
+}
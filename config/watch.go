@@ -0,0 +1,56 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const DefaultInterval = 5 * time.Second
+
+// Watch loads file, makes it the current config and polls it for changes.
+// Every successfully loaded change becomes the current config and is sent on
+// the returned channel. A change that fails to load is logged and the
+// previous config stays in effect.
+func Watch(file string, interval time.Duration) (<-chan *Config, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := Load(file)
+	if err != nil {
+		return nil, err
+	}
+	set(c)
+
+	changes := make(chan *Config, 1)
+	go func() {
+		for range time.Tick(interval) {
+			newContent, err := ioutil.ReadFile(file)
+			if err != nil {
+				logrus.Errorf("Failed to read config %s: %v", file, err)
+				continue
+			}
+
+			if bytes.Equal(content, newContent) {
+				continue
+			}
+			content = newContent
+
+			c, err := Load(file)
+			if err != nil {
+				logrus.Errorf("Ignoring changed config: %v", err)
+				continue
+			}
+
+			logrus.Infof("Reloaded config %s", file)
+			set(c)
+			changes <- c
+		}
+	}()
+
+	return changes, nil
+}
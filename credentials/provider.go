@@ -0,0 +1,289 @@
+package credentials
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	DefaultTTL     = time.Hour
+	DefaultGrace   = 10 * time.Minute
+	DefaultTimeout = 2 * time.Minute
+
+	keyName       = "rancher-compose-executor"
+	expiresPrefix = "Expires "
+)
+
+// Key is a project scoped API key minted by the executor.
+type Key struct {
+	AccessKey string
+	SecretKey string
+	Expires   time.Time
+
+	apiKey *client.ApiKey
+	client *client.RancherClient
+
+	// users counts the creates holding the key, an expired key is only
+	// revoked once the last of them releases it
+	users   int
+	expired bool
+}
+
+// Provider mints short lived API keys per project with the executor's own
+// key and caches them until they expire. A key is only handed out while it
+// has more than Grace left, and is not revoked while a create that got it has
+// not released it. Minting for one project does not hold up the others and
+// waits at most Timeout for the key to become active.
+type Provider struct {
+	TTL     time.Duration
+	Grace   time.Duration
+	Timeout time.Duration
+
+	lock    sync.Mutex
+	keys    map[string]*Key
+	minting map[string]*sync.Mutex
+	held    map[string]bool
+}
+
+func NewProvider() *Provider {
+	return &Provider{
+		TTL:     DefaultTTL,
+		Grace:   DefaultGrace,
+		Timeout: DefaultTimeout,
+		keys:    map[string]*Key{},
+		minting: map[string]*sync.Mutex{},
+		held:    map[string]bool{},
+	}
+}
+
+// Get returns a key scoped to accountId, minting one with global if there is
+// no cached key left. The key stays valid until it is passed to Release.
+func (p *Provider) Get(global *client.RancherClient, accountId string) (*Key, error) {
+	// Keys minted with a previous global key are not reused after it rotates
+	cacheKey := global.Opts.AccessKey + "/" + accountId
+
+	p.lock.Lock()
+	minting, ok := p.minting[cacheKey]
+	if !ok {
+		minting = &sync.Mutex{}
+		p.minting[cacheKey] = minting
+	}
+	p.lock.Unlock()
+
+	minting.Lock()
+	defer minting.Unlock()
+
+	p.lock.Lock()
+	if key, ok := p.keys[cacheKey]; ok && time.Now().Add(p.Grace).Before(key.Expires) {
+		p.hold(key)
+		p.lock.Unlock()
+		return key, nil
+	}
+	p.lock.Unlock()
+
+	key, err := p.mint(global, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	p.keys[cacheKey] = key
+	p.hold(key)
+	p.lock.Unlock()
+
+	time.AfterFunc(key.Expires.Sub(time.Now()), func() {
+		p.expire(cacheKey, key)
+	})
+
+	return key, nil
+}
+
+// Release gives back a key returned by Get.
+func (p *Provider) Release(key *Key) {
+	p.lock.Lock()
+	key.users--
+	revoke := key.users == 0 && key.expired
+	if key.users == 0 {
+		delete(p.held, key.AccessKey)
+	}
+	p.lock.Unlock()
+
+	if revoke {
+		p.revoke(key)
+	}
+}
+
+// hold must be called with the lock held.
+func (p *Provider) hold(key *Key) {
+	key.users++
+	p.held[key.AccessKey] = true
+}
+
+func (p *Provider) mint(global *client.RancherClient, accountId string) (*Key, error) {
+	projectClient, err := newProjectClient(global, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := time.Now().Add(p.TTL)
+	apiKey, err := projectClient.ApiKey.Create(&client.ApiKey{
+		Name:        keyName,
+		Description: expiresPrefix + expires.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create API key for project %s: %v", accountId, err)
+	}
+
+	if err := p.wait(projectClient, apiKey); err != nil {
+		return nil, err
+	}
+
+	if apiKey.State != "active" {
+		return nil, fmt.Errorf("API key for project %s is %s", accountId, apiKey.State)
+	}
+
+	logrus.Infof("Created API key %s for project %s", apiKey.PublicValue, accountId)
+
+	return &Key{
+		AccessKey: apiKey.PublicValue,
+		SecretKey: apiKey.SecretValue,
+		Expires:   expires,
+		apiKey:    apiKey,
+		client:    projectClient,
+	}, nil
+}
+
+func (p *Provider) expire(cacheKey string, key *Key) {
+	p.lock.Lock()
+	if p.keys[cacheKey] == key {
+		delete(p.keys, cacheKey)
+	}
+	key.expired = true
+	revoke := key.users == 0
+	p.lock.Unlock()
+
+	if revoke {
+		p.revoke(key)
+	}
+}
+
+// Sweep revokes the expired keys of all projects that were minted by a
+// provider and never revoked, like those of a previous run of the executor.
+// Keys this provider still hands out or that are held are left alone.
+func (p *Provider) Sweep(global *client.RancherClient) error {
+	projects, err := global.Project.List(&client.ListOpts{
+		Filters: map[string]interface{}{
+			"removed_null": nil,
+			"limit":        -1,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, project := range projects.Data {
+		projectClient, err := newProjectClient(global, project.Id)
+		if err != nil {
+			return err
+		}
+
+		apiKeys, err := projectClient.ApiKey.List(&client.ListOpts{
+			Filters: map[string]interface{}{
+				"name":         keyName,
+				"removed_null": nil,
+				"limit":        -1,
+			},
+		})
+		if err != nil {
+			logrus.Errorf("Failed to list API keys of project %s: %v", project.Id, err)
+			continue
+		}
+
+		for i := range apiKeys.Data {
+			apiKey := &apiKeys.Data[i]
+			expires, err := time.Parse(time.RFC3339, strings.TrimPrefix(apiKey.Description, expiresPrefix))
+			if err != nil || expires.After(time.Now()) || p.inUse(apiKey.PublicValue) {
+				continue
+			}
+
+			p.revoke(&Key{
+				AccessKey: apiKey.PublicValue,
+				apiKey:    apiKey,
+				client:    projectClient,
+			})
+		}
+	}
+
+	return nil
+}
+
+func (p *Provider) inUse(accessKey string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.held[accessKey] {
+		return true
+	}
+	for _, key := range p.keys {
+		if key.AccessKey == accessKey {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Provider) revoke(key *Key) {
+	if err := p.revokeKey(key); err != nil {
+		logrus.Errorf("Failed to revoke API key %s: %v", key.AccessKey, err)
+	}
+}
+
+func (p *Provider) revokeKey(key *Key) error {
+	logrus.Infof("Revoking API key %s", key.AccessKey)
+
+	if key.apiKey.State == "active" {
+		if _, err := key.client.ApiKey.ActionDeactivate(key.apiKey); err != nil {
+			return err
+		}
+	}
+
+	apiKey, err := key.client.ApiKey.ById(key.apiKey.Id)
+	if err != nil || apiKey == nil {
+		return err
+	}
+
+	if err := p.wait(key.client, apiKey); err != nil {
+		return err
+	}
+
+	return key.client.ApiKey.Delete(apiKey)
+}
+
+// wait reloads apiKey until it is no longer transitioning, for at most
+// Timeout.
+func (p *Provider) wait(projectClient *client.RancherClient, apiKey *client.ApiKey) error {
+	deadline := time.Now().Add(p.Timeout)
+	for apiKey.Transitioning == "yes" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for API key %s", apiKey.PublicValue)
+		}
+		time.Sleep(150 * time.Millisecond)
+		if err := projectClient.Reload(&apiKey.Resource, apiKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newProjectClient(global *client.RancherClient, accountId string) (*client.RancherClient, error) {
+	return client.NewRancherClient(&client.ClientOpts{
+		Url:       fmt.Sprintf("%s/projects/%s/schemas", global.Opts.Url, accountId),
+		AccessKey: global.Opts.AccessKey,
+		SecretKey: global.Opts.SecretKey,
+	})
+}
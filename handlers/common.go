@@ -29,3 +29,25 @@ func newReply(event *events.Event) *client.Publish {
 		PreviousIds: []string{event.Id},
 	}
 }
+
+// stackData returns the value stored under key in the stack's data. Values
+// set through the API end up under the "fields" key, so both are checked.
+func stackData(env *client.Environment, key string) interface{} {
+	if v, ok := env.Data[key]; ok {
+		return v
+	}
+	if fields, ok := env.Data["fields"].(map[string]interface{}); ok {
+		return fields[key]
+	}
+	return nil
+}
+
+func stackFlag(env *client.Environment, key string) bool {
+	switch v := stackData(env, key).(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
@@ -1,17 +1,44 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/libcompose/project"
 	"github.com/rancher/go-machine-service/events"
 	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-compose-executor/build"
+	"github.com/rancher/rancher-compose-executor/config"
+	"github.com/rancher/rancher-compose-executor/credentials"
 	"github.com/rancher/rancher-compose-executor/lookup"
+	"github.com/rancher/rancher-compose-executor/preprocess"
+	"github.com/rancher/rancher-compose-executor/secrets"
+	"github.com/rancher/rancher-compose-executor/stack"
 	"github.com/rancher/rancher-compose/rancher"
 )
 
+// projectKeys hands out the project scoped keys used for all calls made on
+// behalf of a stack. The executor's own key only subscribes to events, loads
+// the stack from the event and publishes replies.
+var projectKeys = credentials.NewProvider()
+
+// SweepProjectKeys revokes expired project keys that an earlier run of the
+// executor did not get to revoke.
+func SweepProjectKeys(apiClient *client.RancherClient) error {
+	return projectKeys.Sweep(apiClient)
+}
+
+// Uploader stores the build contexts of services that build from a local
+// directory. It is set up by main.
+var Uploader rancher.Uploader
+
+// BuildImages records the images generated for builds so unused ones can be
+// garbage collected. It is set up by main.
+var BuildImages *build.Images
+
 func CreateEnvironment(event *events.Event, apiClient *client.RancherClient) error {
 	logger := logrus.WithFields(logrus.Fields{
 		"resourceId": event.ResourceId,
@@ -44,40 +71,275 @@ func createEnvironment(logger *logrus.Entry, event *events.Event, apiClient *cli
 		return emptyReply(event, apiClient)
 	}
 
-	project, err := constructProject(logger, env, apiClient.Opts.Url, apiClient.Opts.AccessKey, apiClient.Opts.SecretKey)
+	if err := checkSignature(logger, env); err != nil {
+		return err
+	}
+
+	accessKey, secretKey := apiClient.Opts.AccessKey, apiClient.Opts.SecretKey
+	if config.Current().Enabled(config.FeatureProjectKeys, true) {
+		key, err := projectKeys.Get(apiClient, env.AccountId)
+		if err != nil {
+			return err
+		}
+		// Held until the create is done, however long it takes
+		defer projectKeys.Release(key)
+		accessKey, secretKey = key.AccessKey, key.SecretKey
+	}
+
+	context, resources, err := constructProject(logger, env, apiClient.Opts.Url, accessKey, secretKey)
 	if err != nil {
 		return err
 	}
 
+	if err := checkPolicy(logger, event, apiClient, env, context); err != nil {
+		return err
+	}
+
+	if err := context.CheckReferences(env.Id); err != nil {
+		return err
+	}
+
+	cleanupGit, err := build.FetchGitContexts(context.Project.Configs, resources.Git)
+	if err != nil {
+		return err
+	}
+	defer cleanupGit()
+
+	cleanupInline, err := build.WriteInlineContexts(context.Project.Configs, resources.Builds, config.Current().Builds.InlineMaxBytes, config.Current().Builds.InlineMaxFiles)
+	if err != nil {
+		return err
+	}
+	defer cleanupInline()
+
+	if err := provisionRegistries(logger, context.Client, resources.Registries); err != nil {
+		return err
+	}
+
+	if stackFlag(env, "prePull") || config.Current().Enabled(config.FeaturePrePull, false) {
+		if err := prePull(logger, event, apiClient, context); err != nil {
+			return err
+		}
+	}
+
 	publishTransitioningReply("Creating stack", event, apiClient)
 
-	if err := project.Create(); err != nil {
+	progress := build.NewProgress(context.Client, env.Id, context.Project.Configs, func(msg string) {
+		publishTransitioningReply(msg, event, apiClient)
+	})
+	progress.Start()
+
+	err = context.Project.Create()
+	progress.Stop()
+
+	if err == nil && config.Current().Enabled(config.FeatureFollowBuilds, true) {
+		err = progress.Follow()
+	}
+
+	if err := progress.Error(err); err != nil {
 		return err
 	}
 
 	return emptyReply(event, apiClient)
 }
 
-func constructProject(logger *logrus.Entry, env *client.Environment, url, accessKey, secretKey string) (*project.Project, error) {
+// prePull pulls the images of all services to the hosts they can run on
+// before any service is created, so a missing image or bad credentials fail
+// the stack right away and first starts do not wait for large pulls. Images
+// already on a host are not pulled again.
+func prePull(logger *logrus.Entry, event *events.Event, apiClient *client.RancherClient, context *rancher.Context) error {
+	publishTransitioningReply("Pulling images", event, apiClient)
+
+	context.PullCached = true
+	context.PullProgress = func(image, message string) {
+		publishTransitioningReply(fmt.Sprintf("Pulling %s: %s", image, message), event, apiClient)
+	}
+	defer func() {
+		context.PullProgress = nil
+	}()
+
+	if err := context.Project.Pull(); err != nil {
+		return fmt.Errorf("Failed to pull images: %v", err)
+	}
+
+	logger.Info("Pulled images")
+	return nil
+}
+
+// stackResources holds what the stack section declares besides the services,
+// with every variable it refers to looked up.
+type stackResources struct {
+	Registries []stack.RegistryCredential
+	Git        []stack.GitCredential
+	Builds     map[string]stack.InlineBuild
+}
+
+func constructProject(logger *logrus.Entry, env *client.Environment, url, accessKey, secretKey string) (*rancher.Context, *stackResources, error) {
+	// Questions are answered first so templates see their defaults
+	questions, err := stack.Parse(stack.ExtractSections([]byte(env.RancherCompose)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	environment, err := questions.Validate(env.Environment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dockerCompose, rancherCompose, err := renderTemplates(env, questions.Coerce(environment))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stackConfig, err := stack.Parse([]byte(rancherCompose))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secretProvider, err := secrets.New(config.Current().Secrets)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	envLookup := &lookup.MapEnvLookup{
+		Env:     environment,
+		Secrets: secretProvider,
+		Account: env.AccountId,
+	}
+
+	if err := envLookup.ResolveSecrets(); err != nil {
+		return nil, nil, err
+	}
+
+	registries, err := stackConfig.RegistryCredentials(envLookup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	git, err := stackConfig.GitCredentials(envLookup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	builds, err := inlineBuilds(env, stackConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := stack.ValidateRancherCompose([]byte(dockerCompose), []byte(rancherCompose), envLookup); err != nil {
+		return nil, nil, err
+	}
+
+	rancherComposeBytes, err := stack.Strip([]byte(rancherCompose))
+	if err != nil {
+		return nil, nil, err
+	}
+
 	context := rancher.Context{
 		Context: project.Context{
-			ProjectName:  env.Name,
-			ComposeBytes: []byte(env.DockerCompose),
-			EnvironmentLookup: &lookup.MapEnvLookup{
-				Env: env.Environment,
-			},
+			ProjectName:       env.Name,
+			ComposeBytes:      []byte(dockerCompose),
+			EnvironmentLookup: envLookup,
 		},
 		Url:                 fmt.Sprintf("%s/projects/%s/schemas", url, env.AccountId),
 		AccessKey:           accessKey,
 		SecretKey:           secretKey,
-		RancherComposeBytes: []byte(env.RancherCompose),
+		RancherComposeBytes: rancherComposeBytes,
+		Defaults:            stackConfig.Defaults,
+		Uploader:            Uploader,
+	}
+
+	if BuildImages != nil {
+		context.LaunchConfigFilters = append(context.LaunchConfigFilters, BuildImages)
 	}
 
 	p, err := rancher.NewProject(&context)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if err := checkBuildArgs(p.Configs, envLookup); err != nil {
+		return nil, nil, err
 	}
 
+	// NewProject has already parsed the project, parsing again would drop the
+	// stack defaults merged into the service configs
 	p.AddListener(NewListenLogger(logger, p))
-	return p, p.Parse()
+	return &context, &stackResources{
+		Registries: registries,
+		Git:        git,
+		Builds:     builds,
+	}, nil
+}
+
+// checkBuildArgs rejects build args that carry a secret. They are written
+// into the uploaded Dockerfile and end up in the image history.
+func checkBuildArgs(configs map[string]*project.ServiceConfig, envLookup *lookup.MapEnvLookup) error {
+	names := []string{}
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for arg, value := range configs[name].BuildArgs {
+			if variable, ok := envLookup.SecretIn(value); ok {
+				return fmt.Errorf("Build arg %s of %s uses the secret %s, secrets can not be passed to builds", arg, name, variable)
+			}
+		}
+	}
+
+	return nil
+}
+
+// inlineBuilds returns the inline builds from the stack section, replaced per
+// service by those in the stack's "builds" data.
+func inlineBuilds(env *client.Environment, stackConfig *stack.Config) (map[string]stack.InlineBuild, error) {
+	builds := map[string]stack.InlineBuild{}
+	for name, inline := range stackConfig.Builds {
+		builds[name] = inline
+	}
+
+	data := stackData(env, "builds")
+	if data == nil {
+		return builds, nil
+	}
+
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	fromData := map[string]stack.InlineBuild{}
+	if err := json.Unmarshal(bytes, &fromData); err != nil {
+		return nil, fmt.Errorf("Invalid builds in stack data: %v", err)
+	}
+
+	for name, inline := range fromData {
+		builds[name] = inline
+	}
+
+	return builds, nil
+}
+
+// renderTemplates runs the compose files through text/template with the
+// validated and coerced environment when the stack opted in with the "composeTemplate"
+// data flag. Stacks without the flag are returned untouched so plain $VAR
+// compose files keep working, as are all stacks while the templates feature
+// is switched off.
+func renderTemplates(env *client.Environment, environment map[string]interface{}) (string, string, error) {
+	if !stackFlag(env, "composeTemplate") || !config.Current().Enabled(config.FeatureTemplates, true) {
+		return env.DockerCompose, env.RancherCompose, nil
+	}
+
+	dockerCompose, err := preprocess.Template("docker-compose.yml", env.DockerCompose, environment)
+	if err != nil {
+		return "", "", err
+	}
+
+	rancherCompose, err := preprocess.Template("rancher-compose.yml", env.RancherCompose, environment)
+	if err != nil {
+		return "", "", err
+	}
+
+	return dockerCompose, rancherCompose, nil
 }
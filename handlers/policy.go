@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/go-machine-service/events"
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-compose-executor/config"
+	"github.com/rancher/rancher-compose-executor/policy"
+	"github.com/rancher/rancher-compose/rancher"
+)
+
+// checkPolicy evaluates the parsed project against the rules, the image
+// policy, the capabilities and the quotas in the configured policy file. The
+// file is read on every event so edits apply without a restart.
+func checkPolicy(logger *logrus.Entry, event *events.Event, apiClient *client.RancherClient, env *client.Environment, context *rancher.Context) error {
+	file := config.Current().PolicyFile
+	if file == "" {
+		return nil
+	}
+
+	p, err := policy.Load(file)
+	if err != nil {
+		return err
+	}
+
+	warnings, err := p.Evaluate(env.AccountId, context.Project.Configs, context.RancherConfig)
+	for _, warning := range warnings {
+		logger.Warnf("Policy warning: %s", warning)
+		publishTransitioningReply("Policy warning: "+warning.String(), event, apiClient)
+	}
+
+	imageFilter := p.ImageFilter(env.AccountId)
+	imageErr := imageFilter.Check(context.Project.Configs)
+
+	capabilitiesErr := p.Capabilities.ForAccount(env.AccountId).Check(context.Project.Configs, context.RancherConfig)
+
+	var quotaErr error
+	if quota := p.Quotas.ForAccount(env.AccountId); quota != (policy.Quota{}) {
+		usage, err := policy.LoadUsage(context.Client, env.Id)
+		if err != nil {
+			return err
+		}
+		quotaErr = quota.Check(usage, context.Project.Configs, context.RancherConfig)
+	}
+
+	if err != nil || imageErr != nil || capabilitiesErr != nil || quotaErr != nil {
+		return joinPolicyErrors(err, imageErr, capabilitiesErr, quotaErr)
+	}
+
+	context.LaunchConfigFilters = append(context.LaunchConfigFilters, imageFilter)
+	return nil
+}
+
+func joinPolicyErrors(errs ...error) error {
+	result := &policy.Error{}
+	for _, err := range errs {
+		if policyErr, ok := err.(*policy.Error); ok {
+			result.Violations = append(result.Violations, policyErr.Violations...)
+		} else if err != nil {
+			return err
+		}
+	}
+	return result
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-compose-executor/stack"
+)
+
+// registryTimeout bounds the wait for a new registry to become usable.
+const registryTimeout = 2 * time.Minute
+
+// provisionRegistries makes sure the account has a registry and a credential
+// for every registry the stack declares. Registries are matched by server
+// address and credentials by username, so existing ones are reused rather
+// than duplicated. An existing credential that differs from the declared one
+// is shared with other stacks of the account, so it is only replaced if the
+// registry opts in with overwrite.
+func provisionRegistries(logger *logrus.Entry, apiClient *client.RancherClient, credentials []stack.RegistryCredential) error {
+	for _, credential := range credentials {
+		registry, err := findOrCreateRegistry(logger, apiClient, credential.Server)
+		if err != nil {
+			return fmt.Errorf("Failed to set up registry %s: %v", credential.Server, err)
+		}
+
+		if err := createOrUpdateCredential(logger, apiClient, registry, credential); err != nil {
+			return fmt.Errorf("Failed to set up credential for registry %s: %v", credential.Server, err)
+		}
+	}
+
+	return nil
+}
+
+func findOrCreateRegistry(logger *logrus.Entry, apiClient *client.RancherClient, server string) (*client.Registry, error) {
+	registries, err := apiClient.Registry.List(&client.ListOpts{
+		Filters: map[string]interface{}{
+			"serverAddress": server,
+			"removed_null":  nil,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, registry := range registries.Data {
+		if registry.ServerAddress != server {
+			continue
+		}
+		if registry.State == "inactive" {
+			if _, err := apiClient.Registry.ActionActivate(&registry); err != nil {
+				return nil, err
+			}
+		}
+		logger.Infof("Using existing registry %s for %s", registry.Id, server)
+		return &registry, nil
+	}
+
+	registry, err := apiClient.Registry.Create(&client.Registry{
+		Name:          server,
+		ServerAddress: server,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(registryTimeout)
+	for registry.Transitioning == "yes" {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for registry %s", registry.Id)
+		}
+		time.Sleep(150 * time.Millisecond)
+		if err := apiClient.Reload(&registry.Resource, registry); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Infof("Created registry %s for %s", registry.Id, server)
+	return registry, nil
+}
+
+func createOrUpdateCredential(logger *logrus.Entry, apiClient *client.RancherClient, registry *client.Registry, credential stack.RegistryCredential) error {
+	existing, err := apiClient.RegistryCredential.List(&client.ListOpts{
+		Filters: map[string]interface{}{
+			"registryId":   registry.Id,
+			"removed_null": nil,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, current := range existing.Data {
+		if current.RegistryId != registry.Id || current.PublicValue != credential.Username {
+			continue
+		}
+
+		// The secret is usually not readable, then only the email can differ
+		differs := current.Email != credential.Email || (current.SecretValue != "" && current.SecretValue != credential.Password)
+		if !credential.Overwrite {
+			if differs {
+				return fmt.Errorf("Credential %s for %s already exists with different values, set overwrite to replace it", current.Id, credential.Username)
+			}
+			logger.Infof("Using existing credential %s for %s", current.Id, credential.Server)
+			return nil
+		}
+
+		_, err := apiClient.RegistryCredential.Update(&current, map[string]interface{}{
+			"email":       credential.Email,
+			"secretValue": credential.Password,
+		})
+		if err == nil {
+			logger.Infof("Updated credential %s for %s", current.Id, credential.Server)
+		}
+		return err
+	}
+
+	created, err := apiClient.RegistryCredential.Create(&client.RegistryCredential{
+		RegistryId:  registry.Id,
+		PublicValue: credential.Username,
+		SecretValue: credential.Password,
+		Email:       credential.Email,
+	})
+	if err == nil {
+		logger.Infof("Created credential %s for %s", created.Id, credential.Server)
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-compose-executor/config"
+	"github.com/rancher/rancher-compose-executor/signing"
+)
+
+// signedData lists the stack data keys that change what gets deployed. Every
+// new key read with stackData or stackFlag has to be added here.
+var signedData = []string{"builds", "composeTemplate", "prePull"}
+
+// checkSignature verifies the "composeSignature" in the stack's data when the
+// stack's account is configured to only run signed stacks. The signature
+// covers the compose files, environment and the data the executor acts on as
+// submitted, so it is checked before anything is rendered or defaulted.
+func checkSignature(logger *logrus.Entry, env *client.Environment) error {
+	settings := config.Current().Signing
+	if !settings.Enforced(env.AccountId) {
+		return nil
+	}
+
+	if settings.TrustedKeys == "" {
+		return fmt.Errorf("Stack rejected: account %s requires signed stacks but no trusted keys are configured", env.AccountId)
+	}
+
+	keys, err := signing.LoadKeys(settings.TrustedKeys)
+	if err != nil {
+		return err
+	}
+
+	signature, _ := stackData(env, "composeSignature").(string)
+	keyId, _ := stackData(env, "composeSignatureKey").(string)
+
+	data := map[string]interface{}{}
+	for _, key := range signedData {
+		if value := stackData(env, key); value != nil {
+			data[key] = value
+		}
+	}
+
+	payload, err := signing.Payload(env.DockerCompose, env.RancherCompose, env.Environment, data)
+	if err != nil {
+		return err
+	}
+
+	if err := keys.Verify(payload, signature, keyId); err != nil {
+		return fmt.Errorf("Stack rejected: %v", err)
+	}
+
+	logger.Infof("Verified signature of stack %s", env.Name)
+	return nil
+}
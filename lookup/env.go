@@ -2,17 +2,81 @@ package lookup
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/docker/libcompose/project"
+	"github.com/rancher/rancher-compose-executor/secrets"
 )
 
+// MapEnvLookup looks up variables in the stack environment. With Secrets set,
+// values of the form secret://<path> are replaced by the secret they refer
+// to, looked up under Account. Resolved values only live in the lookup, Env is
+// never changed.
 type MapEnvLookup struct {
-	Env map[string]interface{}
+	Env     map[string]interface{}
+	Secrets secrets.Provider
+	Account string
+
+	resolved map[string]string
+}
+
+// ResolveSecrets fetches every secret referenced by Env, so a missing secret
+// fails the stack instead of interpolating to an empty value. Without
+// Secrets any secret reference is an error, it would otherwise be used as is.
+func (m *MapEnvLookup) ResolveSecrets() error {
+	keys := []string{}
+	for key := range m.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	m.resolved = map[string]string{}
+	for _, key := range keys {
+		path, ok, err := secrets.Path(m.Env[key])
+		if err != nil {
+			return fmt.Errorf("Failed to resolve %s: %v", key, err)
+		}
+		if !ok {
+			continue
+		}
+		if m.Secrets == nil {
+			return fmt.Errorf("Failed to resolve %s: no secrets provider is configured", key)
+		}
+
+		value, err := m.Secrets.Get(secrets.Scope(m.Account, path))
+		if err != nil {
+			return fmt.Errorf("Failed to resolve %s: %v", key, err)
+		}
+		m.resolved[key] = value
+	}
+
+	return nil
 }
 
 func (m *MapEnvLookup) Lookup(key, serviceName string, config *project.ServiceConfig) []string {
+	if v, ok := m.resolved[key]; ok {
+		return []string{fmt.Sprintf("%s=%s", key, v)}
+	}
 	if v, ok := m.Env[key]; ok {
 		return []string{fmt.Sprintf("%s=%v", key, v)}
 	}
 	return []string{}
 }
+
+// SecretIn returns the variable of a resolved secret that value contains, so
+// callers can keep secrets out of places that are not kept secret.
+func (m *MapEnvLookup) SecretIn(value string) (string, bool) {
+	keys := []string{}
+	for key := range m.resolved {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if secret := m.resolved[key]; secret != "" && strings.Contains(value, secret) {
+			return key, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,40 @@
+package lookup
+
+import (
+	"fmt"
+	"testing"
+)
+
+type testProvider map[string]string
+
+func (p testProvider) Get(path string) (string, error) {
+	if v, ok := p[path]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("Secret %s not found", path)
+}
+
+func TestResolveSecrets(t *testing.T) {
+	env := map[string]interface{}{
+		"PASSWORD": "secret://db/password",
+		"USER":     "admin",
+	}
+
+	m := &MapEnvLookup{Env: env}
+	if err := m.ResolveSecrets(); err == nil {
+		t.Fatal("Expected a secret reference without a provider to fail")
+	}
+
+	m = &MapEnvLookup{Env: map[string]interface{}{"USER": "admin"}}
+	if err := m.ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+
+	m = &MapEnvLookup{Env: env, Secrets: testProvider{"1a5/db/password": "hunter2"}, Account: "1a5"}
+	if err := m.ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if v := m.Lookup("PASSWORD", "", nil); len(v) != 1 || v[0] != "PASSWORD=hunter2" {
+		t.Fatal("Unexpected lookup", v)
+	}
+}
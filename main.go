@@ -1,12 +1,20 @@
 package main
 
 import (
+	"net"
+	"net/http"
 	"os"
+	"path"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/go-machine-service/events"
 	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-compose-executor/build"
+	"github.com/rancher/rancher-compose-executor/config"
+	"github.com/rancher/rancher-compose-executor/credentials"
 	"github.com/rancher/rancher-compose-executor/handlers"
+	"github.com/rancher/rancher-compose-executor/uploader"
 )
 
 var (
@@ -20,6 +28,31 @@ func main() {
 
 	logger.Info("Starting rancher-compose-executor")
 
+	var changes <-chan *config.Config
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		var err error
+		changes, err = config.Watch(file, config.DefaultInterval)
+		if err != nil {
+			logrus.WithField("error", err).Fatal("Unable to load config")
+		}
+	}
+
+	if c := config.Current(); c.Enabled(config.FeatureBuilds, c.Builds.Configured()) {
+		setupBuilds(c)
+	}
+	go sweepProjectKeys(credentials.DefaultTTL)
+
+	for c := config.Current(); c != nil; {
+		c = run(c, changes)
+	}
+
+	logger.Info("Exiting rancher-compose-executor")
+}
+
+// run routes events with the settings in c until the connection closes, in
+// which case it returns nil, or until the config changes in a way that needs
+// a new subscription, in which case it returns the new config.
+func run(c *config.Config, changes <-chan *config.Config) *config.Config {
 	eventHandlers := map[string]events.EventHandler{
 		"environment.create": handlers.CreateEnvironment,
 		"ping": func(event *events.Event, apiClient *client.RancherClient) error {
@@ -27,18 +60,165 @@ func main() {
 		},
 	}
 
-	router, err := events.NewEventRouter("rancher-compose-executor", 2000,
-		os.Getenv("CATTLE_URL"),
-		os.Getenv("CATTLE_ACCESS_KEY"),
-		os.Getenv("CATTLE_SECRET_KEY"),
-		nil, eventHandlers, "environment", 10)
+	router, err := events.NewEventRouter("rancher-compose-executor", c.Priority,
+		c.Url,
+		c.AccessKey,
+		c.SecretKey,
+		nil, eventHandlers, "environment", c.Workers)
 	if err != nil {
 		logrus.WithField("error", err).Fatal("Unable to create event router")
 	}
 
-	if err := router.Start(nil); err != nil {
-		logrus.WithField("error", err).Fatal("Unable to start event router")
+	ready := make(chan bool, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- router.Start(ready)
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil && changes == nil {
+				logrus.WithField("error", err).Fatal("Unable to start event router")
+			}
+			if err == nil {
+				return nil
+			}
+			// A bad config can be fixed in place, so wait for the next one
+			logrus.WithField("error", err).Error("Unable to start event router, waiting for config change")
+			return <-changes
+		case newConfig := <-changes:
+			if newConfig.SameSubscription(c) {
+				continue
+			}
+
+			logrus.Info("Config changed, restarting event router")
+			select {
+			case <-ready:
+				router.Stop()
+				<-done
+			case <-done:
+			}
+			return newConfig
+		}
+	}
+}
+
+// setupBuilds sets up the uploader for build contexts and the garbage
+// collection of builds. If that fails builds stay unsupported, the executor
+// still handles stacks that do not build.
+func setupBuilds(c *config.Config) {
+	cache, err := newUploader(c)
+	if err != nil {
+		logrus.WithField("error", err).Error("Unable to set up build uploads, builds are not supported")
+		return
 	}
 
-	logger.Info("Exiting rancher-compose-executor")
+	handlers.Uploader = cache
+	handlers.BuildImages = build.NewImages(path.Join(c.Builds.Dir, "images.json"))
+	go collectBuilds(c.Builds.GC.Interval, cache, handlers.BuildImages)
+}
+
+// newUploader uses S3 when a bucket is configured and otherwise stores build
+// contexts locally, serving them to the agents from this process.
+func newUploader(c *config.Config) (*uploader.Cache, error) {
+	index := path.Join(c.Builds.Dir, "index.json")
+
+	if c.Builds.S3.Bucket != "" {
+		if err := os.MkdirAll(c.Builds.Dir, 0700); err != nil {
+			return nil, err
+		}
+		s3Config := c.Builds.S3
+		// Deployments that pass S3 credentials in the AWS environment keep working
+		if s3Config.AccessKey == "" {
+			s3Config.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+			s3Config.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		return uploader.NewCache(uploader.NewS3(s3Config, c.Builds.TTL), index, c.Builds.TTL), nil
+	}
+
+	url := c.Builds.Url
+	if url == "" {
+		hostname, _ := os.Hostname()
+		_, port, _ := net.SplitHostPort(c.Builds.Listen)
+		url = "http://" + net.JoinHostPort(hostname, port)
+	}
+
+	local, err := uploader.NewLocal(c.Builds.Dir, url, []byte(c.Builds.SigningKey), c.Builds.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", c.Builds.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		logrus.Infof("Serving build contexts on %s as %s", c.Builds.Listen, url)
+		if err := http.Serve(listener, local); err != nil {
+			logrus.WithField("error", err).Error("Stopped serving build contexts")
+		}
+	}()
+
+	return uploader.NewCache(local, index, c.Builds.TTL), nil
+}
+
+// sweepProjectKeys revokes project keys left behind by earlier runs now and
+// then every interval.
+func sweepProjectKeys(interval time.Duration) {
+	for {
+		c := config.Current()
+		if c.Enabled(config.FeatureProjectKeys, true) {
+			apiClient, err := client.NewRancherClient(&client.ClientOpts{
+				Url:       c.Url,
+				AccessKey: c.AccessKey,
+				SecretKey: c.SecretKey,
+			})
+			if err == nil {
+				err = handlers.SweepProjectKeys(apiClient)
+			}
+			if err != nil {
+				logrus.WithField("error", err).Error("Failed to sweep project keys")
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// collectBuilds deletes build contexts and images no service uses anymore
+// every interval, with the credentials and GC settings current at that time.
+func collectBuilds(interval time.Duration, cache *uploader.Cache, images *build.Images) {
+	for range time.Tick(interval) {
+		c := config.Current()
+		if c.Builds.GC.Disabled {
+			continue
+		}
+
+		apiClient, err := client.NewRancherClient(&client.ClientOpts{
+			Url:       c.Url,
+			AccessKey: c.AccessKey,
+			SecretKey: c.SecretKey,
+		})
+		if err != nil {
+			logrus.WithField("error", err).Error("Build garbage collection failed")
+			continue
+		}
+
+		collector := &build.Collector{
+			Client: apiClient,
+			Cache:  cache,
+			Images: images,
+			Grace:  c.Builds.GC.Grace,
+			DryRun: c.Builds.GC.DryRun,
+		}
+
+		report, err := collector.Run()
+		if err != nil {
+			logrus.WithField("error", err).Error("Build garbage collection failed")
+		} else if len(report.Contexts) > 0 || len(report.Images) > 0 || len(report.Failures) > 0 {
+			logrus.Infof("Build garbage collection: %s", report)
+		}
+	}
 }
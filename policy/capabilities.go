@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/docker/libcompose/project"
+	rancherClient "github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-compose/rancher"
+)
+
+// Capabilities restricts which kinds of Rancher resources a stack may
+// create. A list that is not set allows everything, an empty list allows
+// nothing. ServiceKinds holds API types such as service, loadBalancerService,
+// dnsService and externalService. ExternalIps holds addresses or CIDRs. An
+// entry in Accounts replaces all capabilities for that account.
+type Capabilities struct {
+	ServiceKinds []string                `yaml:"service_kinds,omitempty"`
+	Certificates []string                `yaml:"certificates,omitempty"`
+	ExternalIps  []string                `yaml:"external_ips,omitempty"`
+	Accounts     map[string]Capabilities `yaml:"accounts,omitempty"`
+}
+
+func (c Capabilities) ForAccount(accountId string) Capabilities {
+	if capabilities, ok := c.Accounts[accountId]; ok {
+		return capabilities
+	}
+	return c
+}
+
+// Check reports every service that needs a capability the account does not
+// have.
+func (c Capabilities) Check(configs map[string]*project.ServiceConfig, rancherConfigs map[string]rancher.RancherConfig) error {
+	sidekicks := sidekickNames(configs)
+
+	names := []string{}
+	for name := range configs {
+		if !sidekicks[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	violations := []Violation{}
+	for _, name := range names {
+		rancherConfig := rancherConfigs[name]
+
+		kind := rancher.ServiceKind(configs[name], rancherConfig)
+		if c.ServiceKinds != nil && !contains(c.ServiceKinds, kind) {
+			violations = append(violations, Violation{
+				Rule:    "service_kinds",
+				Service: name,
+				Reason:  fmt.Sprintf("is a %s which is not allowed", kind),
+			})
+		}
+
+		if c.Certificates != nil && kind == rancherClient.LOAD_BALANCER_SERVICE_TYPE {
+			certs := rancherConfig.Certs
+			if rancherConfig.DefaultCert != "" {
+				certs = append([]string{rancherConfig.DefaultCert}, certs...)
+			}
+			for _, cert := range certs {
+				if !contains(c.Certificates, cert) {
+					violations = append(violations, Violation{
+						Rule:    "certificates",
+						Service: name,
+						Reason:  fmt.Sprintf("uses certificate %s which is not allowed", cert),
+					})
+				}
+			}
+		}
+
+		if c.ExternalIps != nil {
+			for _, ip := range rancherConfig.ExternalIps {
+				if !ipAllowed(c.ExternalIps, ip) {
+					violations = append(violations, Violation{
+						Rule:    "external_ips",
+						Service: name,
+						Reason:  fmt.Sprintf("uses external IP %s which is not allowed", ip),
+					})
+				}
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+
+	return nil
+}
+
+func ipAllowed(allowed []string, value string) bool {
+	ip := net.ParseIP(value)
+	for _, entry := range allowed {
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+		} else if entry == value || (ip != nil && ip.Equal(net.ParseIP(entry))) {
+			return true
+		}
+	}
+	return false
+}
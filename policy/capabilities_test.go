@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/rancher-compose/rancher"
+	"gopkg.in/yaml.v2"
+)
+
+func TestCapabilities(t *testing.T) {
+	p := &Policy{}
+	if err := yaml.Unmarshal([]byte(`
+capabilities:
+  service_kinds: [service, loadBalancerService, externalService]
+  certificates: [www]
+  external_ips: [10.0.0.0/8, 192.168.1.1]
+  accounts:
+    1a7:
+      service_kinds: [service]
+`), p); err != nil {
+		t.Fatal(err)
+	}
+
+	configs := map[string]*project.ServiceConfig{
+		"web": {
+			Image:  "nginx",
+			Labels: project.NewSliceorMap(map[string]string{"io.rancher.sidekicks": "data"}),
+		},
+		"data": {Image: rancher.DNS_IMAGE},
+		"lb":   {Image: rancher.LB_IMAGE},
+		"dns":  {Image: rancher.DNS_IMAGE},
+		"ext":  {Image: rancher.EXTERNAL_IMAGE},
+	}
+	rancherConfigs := map[string]rancher.RancherConfig{
+		"lb":  {DefaultCert: "www", Certs: []string{"admin"}},
+		"ext": {ExternalIps: []string{"10.1.2.3", "192.168.1.1", "8.8.8.8"}},
+	}
+
+	err := p.Capabilities.ForAccount("1a5").Check(configs, rancherConfigs)
+	if err == nil {
+		t.Fatal("Expected capabilities to be exceeded")
+	}
+
+	for _, msg := range []string{
+		"rule service_kinds: service dns is a dnsService which is not allowed",
+		"rule certificates: service lb uses certificate admin which is not allowed",
+		"rule external_ips: service ext uses external IP 8.8.8.8 which is not allowed",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Error("Missing", msg, "in", err)
+		}
+	}
+
+	for _, msg := range []string{"service data", "certificate www", "10.1.2.3", "192.168.1.1"} {
+		if strings.Contains(err.Error(), msg) {
+			t.Error("Unexpected", msg, "in", err)
+		}
+	}
+
+	err = p.Capabilities.ForAccount("1a7").Check(configs, rancherConfigs)
+	if err == nil || len(err.(*Error).Violations) != 3 {
+		t.Fatal("Expected only plain services to be allowed", err)
+	}
+
+	if err := (Capabilities{}).Check(configs, rancherConfigs); err != nil {
+		t.Fatal("Expected no restrictions", err)
+	}
+}
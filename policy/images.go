@@ -0,0 +1,175 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libcompose/project"
+	rancherClient "github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-compose/rancher"
+)
+
+const OriginalImageLabel = "io.rancher.image.original"
+
+// ImagePolicy restricts where images may come from. An entry in Accounts
+// replaces the whole image policy for that account.
+type ImagePolicy struct {
+	Registries         []string                      `yaml:"registries,omitempty"`
+	RequireDigest      bool                          `yaml:"require_digest,omitempty"`
+	ResolveDigests     bool                          `yaml:"resolve_digests,omitempty"`
+	InsecureRegistries []string                      `yaml:"insecure_registries,omitempty"`
+	Credentials        map[string]RegistryCredential `yaml:"credentials,omitempty"`
+	Accounts           map[string]ImagePolicy        `yaml:"accounts,omitempty"`
+}
+
+func (i ImagePolicy) forAccount(accountId string) ImagePolicy {
+	if policy, ok := i.Accounts[accountId]; ok {
+		return policy
+	}
+	return i
+}
+
+func (i ImagePolicy) allowed(ref Reference) bool {
+	if len(i.Registries) == 0 {
+		return true
+	}
+
+	for _, registry := range i.Registries {
+		registry = strings.TrimSuffix(registry, "/")
+		if ref.Registry == registry || strings.HasPrefix(ref.Name(), registry+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImageFilter checks images before the stack is created and, as a
+// rancher.LaunchConfigFilter, pins resolved digests into the launch configs
+// and checks the images generated for builds. As a rancher.ImagePinner it
+// has images pulled by their pinned reference.
+type ImageFilter struct {
+	policy   ImagePolicy
+	resolver *Resolver
+	pinned   map[string]string
+	lock     sync.Mutex
+}
+
+func (p *Policy) ImageFilter(accountId string) *ImageFilter {
+	policy := p.Images.forAccount(accountId)
+	return &ImageFilter{
+		policy:   policy,
+		resolver: NewResolver(policy.InsecureRegistries, policy.Credentials),
+		pinned:   map[string]string{},
+	}
+}
+
+func skipImage(image string) bool {
+	return image == "" || image == rancher.LB_IMAGE || image == rancher.DNS_IMAGE || image == rancher.EXTERNAL_IMAGE
+}
+
+// Check verifies the image of every service that is not built and resolves
+// digests where the policy asks for it.
+func (f *ImageFilter) Check(configs map[string]*project.ServiceConfig) error {
+	names := []string{}
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	violations := []Violation{}
+	for _, name := range names {
+		config := configs[name]
+		if skipImage(config.Image) || config.Build != "" {
+			continue
+		}
+
+		ref := ParseReference(config.Image)
+		if !f.policy.allowed(ref) {
+			violations = append(violations, Violation{
+				Rule:    "image-registry",
+				Service: name,
+				Reason:  fmt.Sprintf("uses image %s from a registry that is not allowed", config.Image),
+			})
+			continue
+		}
+
+		if ref.Digest != "" {
+			continue
+		}
+
+		if f.policy.ResolveDigests {
+			digest, err := f.resolver.Resolve(ref)
+			if err == nil {
+				f.pin(config.Image, ref.Name()+"@"+digest)
+				continue
+			}
+			if f.policy.RequireDigest {
+				violations = append(violations, Violation{
+					Rule:    "image-digest",
+					Service: name,
+					Reason:  fmt.Sprintf("uses image %s that could not be resolved to a digest: %v", config.Image, err),
+				})
+			} else {
+				logrus.Warnf("Failed to resolve %s to a digest: %v", config.Image, err)
+			}
+		} else if f.policy.RequireDigest {
+			violations = append(violations, Violation{
+				Rule:    "image-digest",
+				Service: name,
+				Reason:  fmt.Sprintf("uses image %s that is not pinned by digest", config.Image),
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+
+	return nil
+}
+
+func (f *ImageFilter) pin(image, pinned string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.pinned[image] = pinned
+}
+
+// Pinned returns the digest reference image was resolved to, if any.
+func (f *ImageFilter) Pinned(image string) (string, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	pinned, ok := f.pinned[image]
+	return pinned, ok
+}
+
+func (f *ImageFilter) Filter(name string, serviceConfig *project.ServiceConfig, launchConfig *rancherClient.LaunchConfig) error {
+	if launchConfig.Build != nil {
+		image := strings.TrimPrefix(launchConfig.ImageUuid, "docker:")
+		if !f.policy.allowed(ParseReference(image)) {
+			return &Error{Violations: []Violation{{
+				Rule:    "image-registry",
+				Service: name,
+				Reason:  fmt.Sprintf("builds image %s for a registry that is not allowed", image),
+			}}}
+		}
+		return nil
+	}
+
+	pinned, ok := f.Pinned(serviceConfig.Image)
+	if !ok {
+		return nil
+	}
+
+	logrus.Infof("Pinning %s for %s to %s", serviceConfig.Image, name, pinned)
+	launchConfig.ImageUuid = "docker:" + pinned
+	if launchConfig.Labels == nil {
+		launchConfig.Labels = map[string]interface{}{}
+	}
+	launchConfig.Labels[OriginalImageLabel] = serviceConfig.Image
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/libcompose/project"
+	rancherClient "github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-compose/rancher"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func newTestRegistry(t *testing.T) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/token":
+			w.Write([]byte(`{"token": "secret"}`))
+		case req.Header.Get("Authorization") != "Bearer secret":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+req.Host+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case req.URL.Path == "/v2/team/app/manifests/1.0":
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return server, strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestParseReference(t *testing.T) {
+	for image, expected := range map[string]string{
+		"nginx":                     "docker.io/library/nginx:latest",
+		"team/app:1.0":              "docker.io/team/app:1.0",
+		"localhost:5000/app":        "localhost:5000/app:latest",
+		"registry.example.com/a/b":  "registry.example.com/a/b:latest",
+		"app@" + testDigest:         "docker.io/library/app@" + testDigest,
+		"docker:registry:5000/x:v1": "registry:5000/x:v1",
+	} {
+		if ref := ParseReference(image); ref.String() != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, image, ref)
+		}
+	}
+}
+
+func TestImagePinning(t *testing.T) {
+	server, registry := newTestRegistry(t)
+	defer server.Close()
+
+	p := &Policy{
+		Images: ImagePolicy{
+			Registries:         []string{registry},
+			RequireDigest:      true,
+			ResolveDigests:     true,
+			InsecureRegistries: []string{registry},
+		},
+	}
+
+	filter := p.ImageFilter("1a5")
+	image := registry + "/team/app:1.0"
+
+	err := filter.Check(map[string]*project.ServiceConfig{
+		"web": {Image: image},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	launchConfig := &rancherClient.LaunchConfig{
+		ImageUuid: "docker:" + image,
+	}
+	if err := filter.Filter("web", &project.ServiceConfig{Image: image}, launchConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	if launchConfig.ImageUuid != "docker:"+registry+"/team/app@"+testDigest {
+		t.Fatal("Image was not pinned", launchConfig.ImageUuid)
+	}
+
+	if launchConfig.Labels[OriginalImageLabel] != image {
+		t.Fatal("Original image label missing", launchConfig.Labels)
+	}
+
+	var pinner rancher.ImagePinner = filter
+	if pinned, ok := pinner.Pinned(image); !ok || pinned != registry+"/team/app@"+testDigest {
+		t.Fatal("Unexpected pinned image", pinned)
+	}
+	if _, ok := pinner.Pinned("nginx"); ok {
+		t.Fatal("Expected an image that was not resolved not to be pinned")
+	}
+}
+
+func TestImageViolations(t *testing.T) {
+	server, registry := newTestRegistry(t)
+	defer server.Close()
+
+	p := &Policy{
+		Images: ImagePolicy{
+			Registries:         []string{registry},
+			InsecureRegistries: []string{registry},
+			Accounts: map[string]ImagePolicy{
+				"1a7": {
+					Registries:         []string{registry},
+					RequireDigest:      true,
+					ResolveDigests:     true,
+					InsecureRegistries: []string{registry},
+				},
+			},
+		},
+	}
+
+	configs := map[string]*project.ServiceConfig{
+		"hub":     {Image: "nginx"},
+		"missing": {Image: registry + "/team/missing:1.0"},
+	}
+
+	err := p.ImageFilter("1a5").Check(configs)
+	if err == nil || !strings.Contains(err.Error(), "rule image-registry: service hub") {
+		t.Fatal("Expected hub to be rejected", err)
+	}
+	if strings.Contains(err.Error(), "service missing") {
+		t.Fatal("Digest should not be required outside of 1a7", err)
+	}
+
+	err = p.ImageFilter("1a7").Check(configs)
+	if err == nil || !strings.Contains(err.Error(), "rule image-digest: service missing") {
+		t.Fatal("Expected unresolvable image to be rejected", err)
+	}
+
+	build := &rancherClient.LaunchConfig{
+		ImageUuid: "docker:stack_web_1",
+		Build:     &rancherClient.DockerBuild{},
+	}
+	if err := p.ImageFilter("1a5").Filter("web", &project.ServiceConfig{}, build); err == nil {
+		t.Fatal("Expected generated build image outside the allowed registries to be rejected")
+	}
+}
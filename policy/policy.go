@@ -0,0 +1,216 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/rancher-compose/rancher"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ActionDeny = "deny"
+	ActionWarn = "warn"
+	ActionOff  = "off"
+)
+
+// Policy is the content of the policy file. Accounts maps an account id to
+// rule name to the action that replaces the default action of that rule.
+type Policy struct {
+	Rules        []Rule                       `yaml:"rules,omitempty"`
+	Accounts     map[string]map[string]string `yaml:"accounts,omitempty"`
+	Images       ImagePolicy                  `yaml:"images,omitempty"`
+	Quotas       Quotas                       `yaml:"quotas,omitempty"`
+	Capabilities Capabilities                 `yaml:"capabilities,omitempty"`
+}
+
+// Rule matches a service if any of its conditions match.
+type Rule struct {
+	Name               string   `yaml:"name"`
+	Action             string   `yaml:"action,omitempty"`
+	Privileged         bool     `yaml:"privileged,omitempty"`
+	Net                []string `yaml:"net,omitempty"`
+	Pid                []string `yaml:"pid,omitempty"`
+	Ipc                []string `yaml:"ipc,omitempty"`
+	Volumes            []string `yaml:"volumes,omitempty"`
+	CapAdd             []string `yaml:"cap_add,omitempty"`
+	Devices            bool     `yaml:"devices,omitempty"`
+	RequireHealthCheck bool     `yaml:"require_health_check,omitempty"`
+}
+
+type Violation struct {
+	Rule    string
+	Action  string
+	Service string
+	Reason  string
+}
+
+func (v Violation) String() string {
+	if v.Service == "" {
+		return fmt.Sprintf("rule %s: %s", v.Rule, v.Reason)
+	}
+	return fmt.Sprintf("rule %s: service %s %s", v.Rule, v.Service, v.Reason)
+}
+
+// Error is returned when at least one deny rule matched.
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	msgs := []string{}
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.String())
+	}
+	return "Stack rejected by policy: " + strings.Join(msgs, "; ")
+}
+
+func Load(file string) (*Policy, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := yaml.Unmarshal(bytes, policy); err != nil {
+		return nil, fmt.Errorf("Failed to parse policy %s: %v", file, err)
+	}
+
+	for _, rule := range policy.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("Failed to parse policy %s: rule without a name", file)
+		}
+		switch rule.Action {
+		case "", ActionDeny, ActionWarn, ActionOff:
+		default:
+			return nil, fmt.Errorf("Failed to parse policy %s: invalid action %s for rule %s", file, rule.Action, rule.Name)
+		}
+	}
+
+	return policy, nil
+}
+
+func (p *Policy) action(accountId string, rule Rule) string {
+	if action, ok := p.Accounts[accountId][rule.Name]; ok {
+		return action
+	}
+	if rule.Action == "" {
+		return ActionDeny
+	}
+	return rule.Action
+}
+
+// Evaluate checks every service of the project. Matches of warn rules are
+// returned as warnings; if any deny rule matched an *Error is returned.
+func (p *Policy) Evaluate(accountId string, configs map[string]*project.ServiceConfig, rancherConfigs map[string]rancher.RancherConfig) ([]Violation, error) {
+	warnings := []Violation{}
+	denied := []Violation{}
+
+	names := []string{}
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, rule := range p.Rules {
+		action := p.action(accountId, rule)
+		if action == ActionOff {
+			continue
+		}
+
+		for _, name := range names {
+			rancherConfig := rancherConfigs[name]
+			for _, reason := range rule.match(configs[name], &rancherConfig) {
+				violation := Violation{
+					Rule:    rule.Name,
+					Action:  action,
+					Service: name,
+					Reason:  reason,
+				}
+				if action == ActionWarn {
+					warnings = append(warnings, violation)
+				} else {
+					denied = append(denied, violation)
+				}
+			}
+		}
+	}
+
+	if len(denied) > 0 {
+		return warnings, &Error{Violations: denied}
+	}
+
+	return warnings, nil
+}
+
+func (r *Rule) match(config *project.ServiceConfig, rancherConfig *rancher.RancherConfig) []string {
+	reasons := []string{}
+
+	if r.Privileged && config.Privileged {
+		reasons = append(reasons, "is privileged")
+	}
+	if contains(r.Net, config.Net) {
+		reasons = append(reasons, "uses net: "+config.Net)
+	}
+	if contains(r.Pid, config.Pid) {
+		reasons = append(reasons, "uses pid: "+config.Pid)
+	}
+	if contains(r.Ipc, config.Ipc) {
+		reasons = append(reasons, "uses ipc: "+config.Ipc)
+	}
+	for _, capability := range config.CapAdd {
+		name := strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+		// Adding ALL adds every capability a rule can deny
+		if contains(r.CapAdd, name) || contains(r.CapAdd, "ALL") || (name == "ALL" && len(r.CapAdd) > 0) {
+			reasons = append(reasons, "adds capability "+capability)
+		}
+	}
+	for _, volume := range config.Volumes {
+		parts := strings.SplitN(volume, ":", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		hostPath := parts[0]
+		for _, denied := range r.Volumes {
+			if overlaps(hostPath, denied) {
+				reasons = append(reasons, "mounts "+hostPath)
+				break
+			}
+		}
+	}
+	if r.Devices && len(config.Devices) > 0 {
+		reasons = append(reasons, "maps devices "+strings.Join(config.Devices, ", "))
+	}
+	if r.RequireHealthCheck && rancherConfig.HealthCheck == nil {
+		reasons = append(reasons, "has no health_check")
+	}
+
+	return reasons
+}
+
+// overlaps returns true if one path is the other or inside it, so mounting a
+// parent of a denied path is caught as well.
+func overlaps(a, b string) bool {
+	a, b = path.Clean(a), path.Clean(b)
+	return a == b || isParent(a, b) || isParent(b, a)
+}
+
+func isParent(parent, child string) bool {
+	return strings.HasPrefix(child, strings.TrimSuffix(parent, "/")+"/")
+}
+
+func contains(list []string, item string) bool {
+	if item == "" {
+		return false
+	}
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/rancher-compose/rancher"
+)
+
+const testPolicy = `
+rules:
+- name: no-privileged
+  privileged: true
+- name: no-docker-sock
+  action: deny
+  volumes:
+  - /var/run/docker.sock
+- name: no-sys-admin
+  action: deny
+  cap_add: [SYS_ADMIN]
+- name: no-host-net
+  action: warn
+  net: [host]
+accounts:
+  1a5:
+    no-privileged: off
+`
+
+func loadTestPolicy(t *testing.T) *Policy {
+	f, err := ioutil.TempFile("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(testPolicy); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	p, err := Load(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestEvaluate(t *testing.T) {
+	p := loadTestPolicy(t)
+
+	configs := map[string]*project.ServiceConfig{
+		"web": {
+			Privileged: true,
+			Net:        "host",
+		},
+		"agent": {
+			Volumes: []string{"/var/run/docker.sock:/var/run/docker.sock"},
+		},
+		"data": {
+			Volumes: []string{"/var/run/docker.sock"},
+		},
+		"parent": {
+			Volumes: []string{"/var/run:/host/run"},
+		},
+		"root": {
+			Volumes: []string{"/:/host"},
+		},
+		"unclean": {
+			Volumes: []string{"/var/run/../run//docker.sock:/sock"},
+		},
+		"caps": {
+			CapAdd: []string{"ALL"},
+		},
+	}
+
+	warnings, err := p.Evaluate("1a7", configs, map[string]rancher.RancherConfig{})
+	if err == nil {
+		t.Fatal("Expected stack to be rejected")
+	}
+
+	for _, msg := range []string{
+		"rule no-privileged: service web is privileged",
+		"rule no-docker-sock: service agent mounts /var/run/docker.sock",
+		"rule no-docker-sock: service parent mounts /var/run",
+		"rule no-docker-sock: service root mounts /",
+		"rule no-docker-sock: service unclean mounts /var/run/../run//docker.sock",
+		"rule no-sys-admin: service caps adds capability ALL",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Fatal("Missing", msg, "in", err)
+		}
+	}
+
+	if strings.Contains(err.Error(), "service data") {
+		t.Fatal("Container only volume should not match", err)
+	}
+
+	if len(warnings) != 1 || warnings[0].Rule != "no-host-net" {
+		t.Fatal("Expected no-host-net warning, got", warnings)
+	}
+}
+
+func TestAccountOverride(t *testing.T) {
+	p := loadTestPolicy(t)
+
+	configs := map[string]*project.ServiceConfig{
+		"web": {
+			Privileged: true,
+		},
+	}
+
+	if _, err := p.Evaluate("1a5", configs, map[string]rancher.RancherConfig{}); err != nil {
+		t.Fatal("Expected override to allow privileged", err)
+	}
+}
@@ -0,0 +1,175 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/libcompose/project"
+	rancherClient "github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-compose/rancher"
+)
+
+// Quota limits what an account may run. A zero value means no limit.
+type Quota struct {
+	MaxStacks           int   `yaml:"max_stacks,omitempty"`
+	MaxServicesPerStack int   `yaml:"max_services_per_stack,omitempty"`
+	MaxTotalScale       int   `yaml:"max_total_scale,omitempty"`
+	MaxMemLimit         int64 `yaml:"max_mem_limit,omitempty"`
+	MaxCPUShares        int64 `yaml:"max_cpu_shares,omitempty"`
+}
+
+// Quotas holds the default quota and per account quotas that replace it.
+type Quotas struct {
+	Quota    `yaml:",inline"`
+	Accounts map[string]Quota `yaml:"accounts,omitempty"`
+}
+
+func (q Quotas) ForAccount(accountId string) Quota {
+	if quota, ok := q.Accounts[accountId]; ok {
+		return quota
+	}
+	return q.Quota
+}
+
+// Usage is what the account already runs outside of the stack being checked.
+type Usage struct {
+	Stacks int
+	Scale  int
+}
+
+// LoadUsage counts the stacks and the scale of the services of the account
+// behind client, leaving out the stack with id environmentId.
+func LoadUsage(client *rancherClient.RancherClient, environmentId string) (Usage, error) {
+	usage := Usage{}
+
+	envs, err := client.Environment.List(&rancherClient.ListOpts{
+		Filters: map[string]interface{}{
+			"removed_null": nil,
+			"limit":        -1,
+		},
+	})
+	if err != nil {
+		return usage, err
+	}
+
+	for _, env := range envs.Data {
+		if env.Id != environmentId {
+			usage.Stacks++
+		}
+	}
+
+	services, err := client.Service.List(&rancherClient.ListOpts{
+		Filters: map[string]interface{}{
+			"removed_null": nil,
+			"limit":        -1,
+		},
+	})
+	if err != nil {
+		return usage, err
+	}
+
+	for _, service := range services.Data {
+		if service.EnvironmentId != environmentId {
+			usage.Scale += int(service.Scale)
+		}
+	}
+
+	return usage, nil
+}
+
+// Check compares a new stack plus the current usage against the quota. Every
+// limit that is exceeded is reported with the amount it is exceeded by.
+func (q Quota) Check(usage Usage, configs map[string]*project.ServiceConfig, rancherConfigs map[string]rancher.RancherConfig) error {
+	violations := []Violation{}
+	sidekicks := sidekickNames(configs)
+
+	if q.MaxStacks > 0 && usage.Stacks+1 > q.MaxStacks {
+		violations = append(violations, Violation{
+			Rule:   "max_stacks",
+			Reason: fmt.Sprintf("%d stacks exceed the limit of %d by %d", usage.Stacks+1, q.MaxStacks, usage.Stacks+1-q.MaxStacks),
+		})
+	}
+
+	names := []string{}
+	for name := range configs {
+		if !sidekicks[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if q.MaxServicesPerStack > 0 && len(names) > q.MaxServicesPerStack {
+		violations = append(violations, Violation{
+			Rule:   "max_services_per_stack",
+			Reason: fmt.Sprintf("%d services exceed the limit of %d by %d", len(names), q.MaxServicesPerStack, len(names)-q.MaxServicesPerStack),
+		})
+	}
+
+	scale := usage.Scale
+	for _, name := range names {
+		if rancherConfigs[name].Scale > 0 {
+			scale += rancherConfigs[name].Scale
+		} else {
+			scale++
+		}
+	}
+
+	if q.MaxTotalScale > 0 && scale > q.MaxTotalScale {
+		violations = append(violations, Violation{
+			Rule:   "max_total_scale",
+			Reason: fmt.Sprintf("total scale %d (%d already running) exceeds the limit of %d by %d", scale, usage.Scale, q.MaxTotalScale, scale-q.MaxTotalScale),
+		})
+	}
+
+	allNames := []string{}
+	for name := range configs {
+		allNames = append(allNames, name)
+	}
+	sort.Strings(allNames)
+
+	for _, name := range allNames {
+		config := configs[name]
+		if q.MaxMemLimit > 0 && !skipImage(config.Image) && (config.MemLimit == 0 || config.MemLimit > q.MaxMemLimit) {
+			violations = append(violations, Violation{
+				Rule:    "max_mem_limit",
+				Service: name,
+				Reason:  memReason(config.MemLimit, q.MaxMemLimit),
+			})
+		}
+		if q.MaxCPUShares > 0 && config.CPUShares > q.MaxCPUShares {
+			violations = append(violations, Violation{
+				Rule:    "max_cpu_shares",
+				Service: name,
+				Reason:  fmt.Sprintf("cpu_shares %d exceeds the limit of %d by %d", config.CPUShares, q.MaxCPUShares, config.CPUShares-q.MaxCPUShares),
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+
+	return nil
+}
+
+// sidekickNames returns the services that become secondary launch configs
+// instead of services of their own.
+func sidekickNames(configs map[string]*project.ServiceConfig) map[string]bool {
+	result := map[string]bool{}
+	for _, config := range configs {
+		for _, name := range strings.Split(config.Labels.MapParts()["io.rancher.sidekicks"], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				result[name] = true
+			}
+		}
+	}
+	return result
+}
+
+func memReason(memLimit, limit int64) string {
+	if memLimit == 0 {
+		return fmt.Sprintf("mem_limit is unlimited but must be at most %d", limit)
+	}
+	return fmt.Sprintf("mem_limit %d exceeds the limit of %d by %d", memLimit, limit, memLimit-limit)
+}
@@ -0,0 +1,64 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/rancher-compose/rancher"
+)
+
+func TestQuota(t *testing.T) {
+	quotas := Quotas{
+		Quota: Quota{
+			MaxStacks:           3,
+			MaxServicesPerStack: 1,
+			MaxTotalScale:       10,
+			MaxMemLimit:         1024,
+			MaxCPUShares:        512,
+		},
+		Accounts: map[string]Quota{
+			"1a5": {},
+		},
+	}
+
+	configs := map[string]*project.ServiceConfig{
+		"web": {
+			Image:     "nginx",
+			MemLimit:  2048,
+			CPUShares: 1024,
+			Labels:    project.NewSliceorMap(map[string]string{"io.rancher.sidekicks": "data"}),
+		},
+		"data": {Image: "busybox", MemLimit: 512},
+		"lb":   {Image: rancher.LB_IMAGE},
+	}
+	rancherConfigs := map[string]rancher.RancherConfig{
+		"web": {Scale: 4},
+	}
+	usage := Usage{Stacks: 3, Scale: 8}
+
+	err := quotas.ForAccount("1a7").Check(usage, configs, rancherConfigs)
+	if err == nil {
+		t.Fatal("Expected quota to be exceeded")
+	}
+
+	for _, msg := range []string{
+		"rule max_stacks: 4 stacks exceed the limit of 3 by 1",
+		"rule max_services_per_stack: 2 services exceed the limit of 1 by 1",
+		"rule max_total_scale: total scale 13 (8 already running) exceeds the limit of 10 by 3",
+		"rule max_mem_limit: service web mem_limit 2048 exceeds the limit of 1024 by 1024",
+		"rule max_cpu_shares: service web cpu_shares 1024 exceeds the limit of 512 by 512",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Error("Missing", msg, "in", err)
+		}
+	}
+
+	if strings.Contains(err.Error(), "service lb") || strings.Contains(err.Error(), "service data") {
+		t.Error("Unexpected violation", err)
+	}
+
+	if err := quotas.ForAccount("1a5").Check(usage, configs, rancherConfigs); err != nil {
+		t.Fatal("Expected no limits for 1a5", err)
+	}
+}
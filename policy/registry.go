@@ -0,0 +1,187 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v1+prettyjws",
+}
+
+type RegistryCredential struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// Resolver looks up the digest of a tag through the registry v2 API.
+type Resolver struct {
+	Client      *http.Client
+	Insecure    []string
+	Credentials map[string]RegistryCredential
+}
+
+func NewResolver(insecure []string, credentials map[string]RegistryCredential) *Resolver {
+	return &Resolver{
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		Insecure:    insecure,
+		Credentials: credentials,
+	}
+}
+
+func (r *Resolver) endpoint(registry string) string {
+	scheme := "https"
+	if contains(r.Insecure, registry) {
+		scheme = "http"
+	}
+	if registry == defaultRegistry {
+		registry = "registry-1.docker.io"
+	}
+	return scheme + "://" + registry
+}
+
+// Resolve returns the digest the tag of ref currently points to.
+func (r *Resolver) Resolve(ref Reference) (string, error) {
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+
+	manifestUrl := fmt.Sprintf("%s/v2/%s/manifests/%s", r.endpoint(ref.Registry), ref.Repository, ref.Tag)
+
+	resp, err := r.head(manifestUrl, "")
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		auth, err := r.authorize(ref, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		if resp, err = r.head(manifestUrl, auth); err != nil {
+			return "", err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to resolve %s: %s", ref, resp.Status)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("Failed to resolve %s: registry did not return a digest", ref)
+	}
+
+	return digest, nil
+}
+
+func (r *Resolver) head(manifestUrl, auth string) (*http.Response, error) {
+	req, err := http.NewRequest("HEAD", manifestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return resp, nil
+}
+
+// authorize answers a Basic or Bearer challenge and returns the value for the
+// Authorization header.
+func (r *Resolver) authorize(ref Reference, challenge string) (string, error) {
+	cred, hasCred := r.Credentials[ref.Registry]
+
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if !hasCred {
+			return "", fmt.Errorf("Failed to resolve %s: registry requires credentials", ref)
+		}
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(cred.Username, cred.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("Failed to resolve %s: unsupported auth challenge %q", ref, challenge)
+	}
+
+	tokenUrl, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+
+	query := tokenUrl.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:pull", ref.Repository))
+	tokenUrl.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", tokenUrl.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCred {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to get token for %s: %s", ref, resp.Status)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+
+	return "Bearer " + token.Token, nil
+}
+
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	scheme := strings.ToLower(parts[0])
+	if len(parts) == 1 {
+		return scheme, params
+	}
+
+	for _, param := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return scheme, params
+}
@@ -0,0 +1,83 @@
+package preprocess
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"text/template"
+)
+
+// Funcs is the complete set of functions available to compose templates.
+// None of them have side effects or access anything beyond their arguments.
+var Funcs = template.FuncMap{
+	"default":  defaultValue,
+	"required": required,
+	"toJson":   toJson,
+	"indent":   indent,
+	"b64enc":   b64enc,
+}
+
+// Template renders contents as a Go text/template using the stack
+// environment as data. Variables that are not set are empty, so default and
+// required work on them.
+func Template(name, contents string, env map[string]interface{}) (string, error) {
+	if contents == "" {
+		return contents, nil
+	}
+
+	t, err := template.New(name).Funcs(Funcs).Parse(contents)
+	if err != nil {
+		return "", err
+	}
+
+	if env == nil {
+		env = map[string]interface{}{}
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := t.Execute(buffer, env); err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	if s, ok := value.(string); ok {
+		return s == ""
+	}
+	return false
+}
+
+func defaultValue(def, value interface{}) interface{} {
+	if isEmpty(value) {
+		return def
+	}
+	return value
+}
+
+func required(msg string, value interface{}) (interface{}, error) {
+	if isEmpty(value) {
+		return nil, errors.New(msg)
+	}
+	return value, nil
+}
+
+func toJson(value interface{}) (string, error) {
+	bytes, err := json.Marshal(value)
+	return string(bytes), err
+}
+
+func indent(spaces int, value string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(value, "\n", "\n"+pad, -1)
+}
+
+func b64enc(value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
@@ -0,0 +1,67 @@
+package preprocess
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	env := map[string]interface{}{
+		"ENABLE_BACKUP": true,
+		"scale":         2,
+		"labels":        map[string]interface{}{"tier": "web"},
+		"config":        "a: 1\nb: 2",
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"image: {{ default \"nginx\" .image }}", "image: nginx"},
+		{"scale: {{ default 1 .scale }}", "scale: 2"},
+		{"{{ if .ENABLE_BACKUP }}backup{{ end }}", "backup"},
+		{"{{ if .ENABLE_MONITORING }}monitoring{{ end }}", ""},
+		{"labels: {{ toJson .labels }}", `labels: {"tier":"web"}`},
+		{"config: |\n{{ indent 2 .config }}", "config: |\n  a: 1\n  b: 2"},
+		{"{{ b64enc \"secret\" }}", "c2VjcmV0"},
+	}
+
+	for _, test := range tests {
+		result, err := Template("docker-compose.yml", test.template, env)
+		if err != nil {
+			t.Fatal(test.template, err)
+		}
+		if result != test.expected {
+			t.Fatalf("Expected %q to render %q, got %q", test.template, test.expected, result)
+		}
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	for _, test := range []struct {
+		template string
+		message  string
+	}{
+		{"image: {{ required \"image is required\" .image }}", "image is required"},
+		{"image: {{ required \"image is required\" .empty }}", "image is required"},
+		{"image: {{ .image", "unclosed action"},
+		{"image: {{ exec \"ls\" }}", "function \"exec\" not defined"},
+	} {
+		_, err := Template("docker-compose.yml", test.template, map[string]interface{}{"empty": ""})
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Fatalf("Expected %q to fail with %q, got %v", test.template, test.message, err)
+		}
+	}
+}
+
+func TestTemplateEmpty(t *testing.T) {
+	result, err := Template("docker-compose.yml", "", nil)
+	if err != nil || result != "" {
+		t.Fatal("Expected empty contents to be returned as is", result, err)
+	}
+
+	result, err = Template("docker-compose.yml", "image: {{ default \"nginx\" .image }}", nil)
+	if err != nil || result != "image: nginx" {
+		t.Fatal("Expected a nil environment to render", result, err)
+	}
+}
@@ -0,0 +1,105 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FileProvider reads secrets from a YAML map of path to value that is
+// encrypted with AES-256-GCM. The key file holds the base64 encoded 32 byte
+// key and the secrets file is the nonce followed by the ciphertext.
+type FileProvider struct {
+	secrets map[string]string
+}
+
+func NewFileProvider(file, keyFile string) (*FileProvider, error) {
+	key, err := readKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := Decrypt(key, content)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt secrets %s: %v", file, err)
+	}
+
+	secrets := map[string]string{}
+	if err := yaml.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("Failed to parse secrets %s", file)
+	}
+
+	return &FileProvider{
+		secrets: secrets,
+	}, nil
+}
+
+func (f *FileProvider) Get(path string) (string, error) {
+	if value, ok := f.secrets[path]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("Secret %s not found", path)
+}
+
+func readKey(keyFile string) ([]byte, error) {
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("Key in %s is not a base64 encoded 32 byte key", keyFile)
+	}
+
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt produces the content of a secrets file from plaintext.
+func Encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func Decrypt(key, content []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) < gcm.NonceSize() {
+		return nil, errors.New("content too short")
+	}
+
+	return gcm.Open(nil, content[:gcm.NonceSize()], content[gcm.NonceSize():], nil)
+}
@@ -0,0 +1,58 @@
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPProvider reads a secret with a GET of <url>/<path>. The response body
+// is the value. A token, if set, is sent as a bearer token.
+type HTTPProvider struct {
+	Client *http.Client
+	Url    string
+	Token  string
+}
+
+func NewHTTPProvider(url, token string) *HTTPProvider {
+	return &HTTPProvider{
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		Url:   strings.TrimSuffix(url, "/"),
+		Token: token,
+	}
+}
+
+func (h *HTTPProvider) Get(path string) (string, error) {
+	req, err := http.NewRequest("GET", h.Url+"/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read secret %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("Secret %s not found", path)
+	default:
+		return "", fmt.Errorf("Failed to read secret %s: %s", path, resp.Status)
+	}
+
+	value, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read secret %s: %v", path, err)
+	}
+
+	return string(value), nil
+}
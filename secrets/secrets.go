@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"fmt"
+	"strings"
+)
+
+const Prefix = "secret://"
+
+// Provider looks up the value of a secret by its path, the part of a
+// reference after "secret://".
+type Provider interface {
+	Get(path string) (string, error)
+}
+
+// Config selects and configures the provider. Type is "file" or "http".
+type Config struct {
+	Type    string `yaml:"type,omitempty"`
+	File    string `yaml:"file,omitempty"`
+	KeyFile string `yaml:"key_file,omitempty"`
+	Url     string `yaml:"url,omitempty"`
+	Token   string `yaml:"token,omitempty"`
+}
+
+// New returns the provider described by c, or nil if none is configured.
+func New(c Config) (Provider, error) {
+	switch c.Type {
+	case "":
+		return nil, nil
+	case "file":
+		return NewFileProvider(c.File, c.KeyFile)
+	case "http":
+		return NewHTTPProvider(c.Url, c.Token), nil
+	}
+	return nil, fmt.Errorf("Unknown secret provider %s", c.Type)
+}
+
+// Path returns the path of a secret reference. A reference is not valid if
+// its path is absolute or has empty, "." or ".." segments.
+func Path(value interface{}) (string, bool, error) {
+	s, ok := value.(string)
+	if !ok || !strings.HasPrefix(s, Prefix) {
+		return "", false, nil
+	}
+
+	path := strings.TrimPrefix(s, Prefix)
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", true, fmt.Errorf("Invalid secret reference %s", s)
+		}
+	}
+	return path, true, nil
+}
+
+// Scope returns path under accountId, so stacks only see the secrets of
+// their own account.
+func Scope(accountId, path string) string {
+	return accountId + "/" + path
+}
@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := Encrypt(key, []byte("db/password: hunter2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile := path.Join(dir, "key")
+	secretsFile := path.Join(dir, "secrets")
+	if err := ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(secretsFile, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := New(Config{Type: "file", File: secretsFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := provider.Get("db/password"); err != nil || value != "hunter2" {
+		t.Fatalf("Unexpected secret %s: %v", value, err)
+	}
+	if _, err := provider.Get("db/user"); err == nil {
+		t.Fatal("Expected missing secret to fail")
+	}
+
+	content[len(content)-1] ^= 1
+	if err := ioutil.WriteFile(secretsFile, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileProvider(secretsFile, keyFile); err == nil {
+		t.Fatal("Expected tampered secrets file to fail")
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/kv/db/password" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("hunter2"))
+	}))
+	defer server.Close()
+
+	provider := NewHTTPProvider(server.URL+"/kv/", "token")
+	if value, err := provider.Get("db/password"); err != nil || value != "hunter2" {
+		t.Fatalf("Unexpected secret %s: %v", value, err)
+	}
+	if _, err := provider.Get("db/user"); err == nil {
+		t.Fatal("Expected missing secret to fail")
+	}
+
+	provider.Token = "wrong"
+	if _, err := provider.Get("db/password"); err == nil {
+		t.Fatal("Expected forbidden secret to fail")
+	}
+}
+
+func TestPath(t *testing.T) {
+	if p, ok, err := Path("secret://db/password"); !ok || err != nil || p != "db/password" {
+		t.Fatalf("Unexpected path %s: %v", p, err)
+	}
+	if _, ok, _ := Path("plain"); ok {
+		t.Fatal("Expected plain value not to be a reference")
+	}
+	if _, ok, _ := Path(1); ok {
+		t.Fatal("Expected number not to be a reference")
+	}
+	for _, ref := range []string{"secret://../1a7/db", "secret:///etc/passwd", "secret://db/./password", "secret://db//password"} {
+		if _, ok, err := Path(ref); !ok || err == nil {
+			t.Errorf("Expected %s to be an invalid reference", ref)
+		}
+	}
+}
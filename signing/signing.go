@@ -0,0 +1,128 @@
+package signing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	ErrUnsigned  = errors.New("Stack is not signed")
+	ErrTampered  = errors.New("Stack signature does not match its content")
+	ErrNoKeys    = errors.New("No trusted keys to verify the stack signature with")
+	ErrBadFormat = errors.New("Stack signature is not valid base64")
+)
+
+// Keys maps a key id to an Ed25519 public key.
+type Keys map[string]ed25519.PublicKey
+
+// LoadKeys reads a YAML file mapping key ids to base64 encoded Ed25519
+// public keys.
+func LoadKeys(file string) (Keys, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := map[string]string{}
+	if err := yaml.Unmarshal(bytes, &encoded); err != nil {
+		return nil, fmt.Errorf("Failed to parse trusted keys %s: %v", file, err)
+	}
+
+	keys := Keys{}
+	for id, value := range encoded {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Trusted key %s in %s is not a base64 encoded Ed25519 public key", id, file)
+		}
+		keys[id] = ed25519.PublicKey(key)
+	}
+
+	return keys, nil
+}
+
+// Payload is the canonical encoding that gets signed. It is the docker
+// compose, the rancher compose, then every environment key and value, sorted
+// by key, and then every data key and its value encoded as JSON, sorted by
+// key. Each is written as a netstring ("<length>:<bytes>,").
+func Payload(dockerCompose, rancherCompose string, environment, data map[string]interface{}) ([]byte, error) {
+	payload := []byte{}
+	add := func(s string) {
+		payload = append(payload, strconv.Itoa(len(s))...)
+		payload = append(payload, ':')
+		payload = append(payload, s...)
+		payload = append(payload, ',')
+	}
+
+	add(dockerCompose)
+	add(rancherCompose)
+
+	for _, key := range sortedKeys(environment) {
+		add(key)
+		add(fmt.Sprint(environment[key]))
+	}
+
+	for _, key := range sortedKeys(data) {
+		// encoding/json writes map keys sorted, so this is canonical too
+		value, err := json.Marshal(data[key])
+		if err != nil {
+			return nil, err
+		}
+		add(key)
+		add(string(value))
+	}
+
+	return payload, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Verify checks the base64 encoded signature over payload. If keyId is set
+// only that key is tried, otherwise any trusted key may match.
+func (k Keys) Verify(payload []byte, signature, keyId string) error {
+	if signature == "" {
+		return ErrUnsigned
+	}
+
+	if len(k) == 0 {
+		return ErrNoKeys
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrBadFormat
+	}
+
+	if keyId != "" {
+		key, ok := k[keyId]
+		if !ok {
+			return fmt.Errorf("Stack is signed with unknown key %s", keyId)
+		}
+		if !ed25519.Verify(key, payload, sig) {
+			return ErrTampered
+		}
+		return nil
+	}
+
+	for _, key := range k {
+		if ed25519.Verify(key, payload, sig) {
+			return nil
+		}
+	}
+
+	return ErrTampered
+}
@@ -0,0 +1,86 @@
+package signing
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestPayloadCanonical(t *testing.T) {
+	data := map[string]interface{}{"prePull": true, "builds": map[string]interface{}{"web": "x"}}
+	a := mustPayload(t, "dc", "rc", map[string]interface{}{"B": "2", "A": "1"}, data)
+	b := mustPayload(t, "dc", "rc", map[string]interface{}{"A": "1", "B": "2"}, data)
+	if a != b {
+		t.Fatalf("Payload depends on map order: %s %s", a, b)
+	}
+
+	if a != `2:dc,2:rc,1:A,1:1,1:B,1:2,6:builds,11:{"web":"x"},7:prePull,4:true,` {
+		t.Fatalf("Unexpected payload %s", a)
+	}
+
+	// Moving bytes between fields must change the payload
+	if mustPayload(t, "dcr", "c", nil, nil) == mustPayload(t, "dc", "rc", nil, nil) {
+		t.Fatal("Payload is ambiguous")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := ioutil.WriteFile(f.Name(), []byte("release: "+base64.StdEncoding.EncodeToString(pub)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadKeys(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]interface{}{"FOO": "bar"}
+	payload := []byte(mustPayload(t, "dc", "rc", env, nil))
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, payload))
+
+	if err := keys.Verify(payload, signature, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Verify(payload, signature, "release"); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Verify(payload, signature, "other"); err == nil {
+		t.Fatal("Expected unknown key to fail")
+	}
+	if err := keys.Verify(payload, "", ""); err != ErrUnsigned {
+		t.Fatalf("Expected unsigned, got %v", err)
+	}
+
+	env["FOO"] = "baz"
+	if err := keys.Verify([]byte(mustPayload(t, "dc", "rc", env, nil)), signature, ""); err != ErrTampered {
+		t.Fatalf("Expected tampered, got %v", err)
+	}
+
+	env["FOO"] = "bar"
+	if err := keys.Verify([]byte(mustPayload(t, "dc", "rc", env, map[string]interface{}{"prePull": true})), signature, ""); err != ErrTampered {
+		t.Fatalf("Expected added data to be tampered, got %v", err)
+	}
+}
+
+func mustPayload(t *testing.T, dockerCompose, rancherCompose string, environment, data map[string]interface{}) string {
+	payload, err := Payload(dockerCompose, rancherCompose, environment, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(payload)
+}
@@ -0,0 +1,9 @@
+package stack
+
+// InlineBuild is a build context carried in the stack itself, for stacks
+// that have no filesystem to build from. Files maps paths relative to the
+// context to their content.
+type InlineBuild struct {
+	Dockerfile string            `yaml:"dockerfile" json:"dockerfile"`
+	Files      map[string]string `yaml:"files,omitempty" json:"files,omitempty"`
+}
@@ -0,0 +1,69 @@
+package stack
+
+import (
+	"fmt"
+
+	"github.com/docker/libcompose/project"
+)
+
+// Git declares the credentials used to fetch git build contexts from Host.
+// Either PasswordVariable or SSHKeyVariable names the stack environment
+// variable holding the password or token, or the private key. A private key
+// also needs KnownHostsVariable, the variable holding the known_hosts lines
+// the host key is checked against.
+type Git struct {
+	Host               string `yaml:"host"`
+	Username           string `yaml:"username,omitempty"`
+	PasswordVariable   string `yaml:"password_variable,omitempty"`
+	SSHKeyVariable     string `yaml:"ssh_key_variable,omitempty"`
+	KnownHostsVariable string `yaml:"known_hosts_variable,omitempty"`
+}
+
+// GitCredential is a Git with its password or key looked up.
+type GitCredential struct {
+	Host       string
+	Username   string
+	Password   string
+	SSHKey     string
+	KnownHosts string
+}
+
+// GitCredentials looks up the password or key of every git host in the stack
+// environment.
+func (c *Config) GitCredentials(envLookup project.EnvironmentLookup) ([]GitCredential, error) {
+	result := []GitCredential{}
+
+	for _, git := range c.Git {
+		if git.Host == "" || (git.PasswordVariable == "") == (git.SSHKeyVariable == "") {
+			return nil, fmt.Errorf("Git host %s needs a host and one of password_variable or ssh_key_variable", git.Host)
+		}
+
+		credential := GitCredential{
+			Host:     git.Host,
+			Username: git.Username,
+		}
+
+		if git.PasswordVariable != "" {
+			credential.Password = lookupVariable(envLookup, git.PasswordVariable)
+			if credential.Password == "" {
+				return nil, fmt.Errorf("Git host %s password variable %s is not set", git.Host, git.PasswordVariable)
+			}
+		} else {
+			credential.SSHKey = lookupVariable(envLookup, git.SSHKeyVariable)
+			if credential.SSHKey == "" {
+				return nil, fmt.Errorf("Git host %s ssh key variable %s is not set", git.Host, git.SSHKeyVariable)
+			}
+			if git.KnownHostsVariable == "" {
+				return nil, fmt.Errorf("Git host %s needs known_hosts_variable to check the host key", git.Host)
+			}
+			credential.KnownHosts = lookupVariable(envLookup, git.KnownHostsVariable)
+			if credential.KnownHosts == "" {
+				return nil, fmt.Errorf("Git host %s known hosts variable %s is not set", git.Host, git.KnownHostsVariable)
+			}
+		}
+
+		result = append(result, credential)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,165 @@
+package stack
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeString   = "string"
+	TypeInt      = "int"
+	TypeFloat    = "float"
+	TypeBoolean  = "boolean"
+	TypePassword = "password"
+	TypeEnum     = "enum"
+)
+
+type Question struct {
+	Variable    string      `yaml:"variable"`
+	Label       string      `yaml:"label,omitempty"`
+	Description string      `yaml:"description,omitempty"`
+	Type        string      `yaml:"type,omitempty"`
+	Default     interface{} `yaml:"default,omitempty"`
+	Required    bool        `yaml:"required,omitempty"`
+	Regex       string      `yaml:"regex,omitempty"`
+	Enum        []string    `yaml:"enum,omitempty"`
+}
+
+// ValidationError lists every problem found while validating a stack, so the
+// user can fix all of them in one go.
+type ValidationError struct {
+	Violations []string
+}
+
+func (v *ValidationError) Error() string {
+	return "Invalid stack: " + strings.Join(v.Violations, "; ")
+}
+
+// Validate checks env against the declared questions and returns a copy of env
+// with the defaults of unanswered questions filled in.
+func (c *Config) Validate(env map[string]interface{}) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	for k, v := range env {
+		result[k] = v
+	}
+
+	violations := []string{}
+	for _, question := range c.Questions {
+		if question.Variable == "" {
+			violations = append(violations, "question without a variable name")
+			continue
+		}
+
+		value, ok := result[question.Variable]
+		if !ok || fmt.Sprint(value) == "" {
+			if question.Default != nil {
+				if err := question.check(fmt.Sprint(question.Default)); err != nil {
+					violations = append(violations, fmt.Sprintf("%s default: %v", question.Variable, err))
+					continue
+				}
+				result[question.Variable] = question.Default
+				continue
+			}
+			if question.Required {
+				violations = append(violations, fmt.Sprintf("%s is required", question.Variable))
+			}
+			continue
+		}
+
+		if err := question.check(fmt.Sprint(value)); err != nil {
+			violations = append(violations, fmt.Sprintf("%s: %v", question.Variable, err))
+		}
+	}
+
+	if len(violations) > 0 {
+		return nil, &ValidationError{Violations: violations}
+	}
+
+	return result, nil
+}
+
+// Coerce returns a copy of a validated env with the answers to int, float and
+// boolean questions converted to those types, for use as template data.
+func (c *Config) Coerce(env map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range env {
+		result[k] = v
+	}
+
+	for _, question := range c.Questions {
+		value, ok := result[question.Variable]
+		if !ok {
+			continue
+		}
+
+		text := fmt.Sprint(value)
+		switch question.Type {
+		case TypeInt:
+			if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+				result[question.Variable] = i
+			}
+		case TypeFloat:
+			if f, err := strconv.ParseFloat(text, 64); err == nil {
+				result[question.Variable] = f
+			}
+		case TypeBoolean:
+			if b, err := strconv.ParseBool(text); err == nil {
+				result[question.Variable] = b
+			}
+		}
+	}
+
+	return result
+}
+
+func (q *Question) check(value string) error {
+	shown := value
+	if q.Type == TypePassword {
+		shown = "value"
+	}
+
+	switch q.Type {
+	case "", TypeString, TypePassword, TypeEnum:
+	case TypeInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("%s is not an int", shown)
+		}
+	case TypeFloat:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s is not a float", shown)
+		}
+	case TypeBoolean:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s is not a boolean", shown)
+		}
+	default:
+		return fmt.Errorf("unknown type %s", q.Type)
+	}
+
+	if len(q.Enum) > 0 && !contains(q.Enum, value) {
+		return fmt.Errorf("%s is not one of [%s]", shown, strings.Join(q.Enum, ", "))
+	}
+
+	if q.Regex != "" {
+		re, err := regexp.Compile(q.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex %s: %v", q.Regex, err)
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("%s does not match %s", shown, q.Regex)
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
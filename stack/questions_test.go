@@ -0,0 +1,117 @@
+package stack
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		questions  []Question
+		env        map[string]interface{}
+		result     map[string]interface{}
+		violations []string
+	}{
+		{
+			name:      "default",
+			questions: []Question{{Variable: "SCALE", Type: TypeInt, Default: 2}},
+			env:       map[string]interface{}{},
+			result:    map[string]interface{}{"SCALE": 2},
+		},
+		{
+			name:      "empty answer takes the default",
+			questions: []Question{{Variable: "NAME", Default: "web"}},
+			env:       map[string]interface{}{"NAME": ""},
+			result:    map[string]interface{}{"NAME": "web"},
+		},
+		{
+			name:       "bad default",
+			questions:  []Question{{Variable: "SCALE", Type: TypeInt, Default: "two"}},
+			env:        map[string]interface{}{},
+			violations: []string{"SCALE default: two is not an int"},
+		},
+		{
+			name:       "default not in enum",
+			questions:  []Question{{Variable: "SIZE", Type: TypeEnum, Enum: []string{"small", "large"}, Default: "medium"}},
+			env:        map[string]interface{}{},
+			violations: []string{"SIZE default: medium is not one of [small, large]"},
+		},
+		{
+			name:       "required",
+			questions:  []Question{{Variable: "NAME", Required: true}},
+			env:        map[string]interface{}{},
+			violations: []string{"NAME is required"},
+		},
+		{
+			name: "types",
+			questions: []Question{
+				{Variable: "INT", Type: TypeInt},
+				{Variable: "FLOAT", Type: TypeFloat},
+				{Variable: "BOOL", Type: TypeBoolean},
+				{Variable: "PASSWORD", Type: TypePassword, Regex: "^.{8,}$"},
+				{Variable: "NAME", Regex: "^[a-z]+$"},
+			},
+			env: map[string]interface{}{"INT": "1.5", "FLOAT": "x", "BOOL": "maybe", "PASSWORD": "short", "NAME": "Web"},
+			violations: []string{
+				"INT: 1.5 is not an int",
+				"FLOAT: x is not a float",
+				"BOOL: maybe is not a boolean",
+				"PASSWORD: value does not match ^.{8,}$",
+				"NAME: Web does not match ^[a-z]+$",
+			},
+		},
+		{
+			name:      "valid answers",
+			questions: []Question{{Variable: "INT", Type: TypeInt}, {Variable: "SIZE", Type: TypeEnum, Enum: []string{"small", "large"}}},
+			env:       map[string]interface{}{"INT": "3", "SIZE": "small", "OTHER": "x"},
+			result:    map[string]interface{}{"INT": "3", "SIZE": "small", "OTHER": "x"},
+		},
+		{
+			name:       "unknown type",
+			questions:  []Question{{Variable: "X", Type: "list"}},
+			env:        map[string]interface{}{"X": "a"},
+			violations: []string{"X: unknown type list"},
+		},
+		{
+			name:       "missing variable",
+			questions:  []Question{{Label: "Name"}},
+			env:        map[string]interface{}{},
+			violations: []string{"question without a variable name"},
+		},
+	}
+
+	for _, test := range tests {
+		c := &Config{Questions: test.questions}
+		result, err := c.Validate(test.env)
+		if len(test.violations) > 0 {
+			validationErr, ok := err.(*ValidationError)
+			if !ok || !reflect.DeepEqual(validationErr.Violations, test.violations) {
+				t.Fatalf("%s: expected %q, got %v", test.name, test.violations, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		if !reflect.DeepEqual(result, test.result) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.result, result)
+		}
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	c := &Config{Questions: []Question{
+		{Variable: "INT", Type: TypeInt},
+		{Variable: "FLOAT", Type: TypeFloat},
+		{Variable: "BOOL", Type: TypeBoolean},
+		{Variable: "NAME"},
+		{Variable: "UNSET", Type: TypeInt},
+	}}
+
+	result := c.Coerce(map[string]interface{}{"INT": "3", "FLOAT": "1.5", "BOOL": "true", "NAME": "3"})
+	expected := map[string]interface{}{"INT": int64(3), "FLOAT": 1.5, "BOOL": true, "NAME": "3"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, got %v", expected, result)
+	}
+}
@@ -0,0 +1,64 @@
+package stack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/libcompose/project"
+)
+
+// Registry declares a private registry the stack pulls from. The password is
+// never written in the file, PasswordVariable names the stack environment
+// variable that holds it. An existing credential of the account for the same
+// username is only replaced if Overwrite is set.
+type Registry struct {
+	Server           string `yaml:"server"`
+	Username         string `yaml:"username"`
+	Email            string `yaml:"email,omitempty"`
+	PasswordVariable string `yaml:"password_variable"`
+	Overwrite        bool   `yaml:"overwrite,omitempty"`
+}
+
+// RegistryCredential is a Registry with its password looked up.
+type RegistryCredential struct {
+	Server    string
+	Username  string
+	Email     string
+	Password  string
+	Overwrite bool
+}
+
+// RegistryCredentials looks up the password of every registry in the stack
+// environment.
+func (c *Config) RegistryCredentials(envLookup project.EnvironmentLookup) ([]RegistryCredential, error) {
+	result := []RegistryCredential{}
+
+	for _, registry := range c.Registries {
+		if registry.Server == "" || registry.Username == "" || registry.PasswordVariable == "" {
+			return nil, fmt.Errorf("Registry %s needs server, username and password_variable", registry.Server)
+		}
+
+		password := lookupVariable(envLookup, registry.PasswordVariable)
+		if password == "" {
+			return nil, fmt.Errorf("Registry %s password variable %s is not set", registry.Server, registry.PasswordVariable)
+		}
+
+		result = append(result, RegistryCredential{
+			Server:    registry.Server,
+			Username:  registry.Username,
+			Email:     registry.Email,
+			Password:  password,
+			Overwrite: registry.Overwrite,
+		})
+	}
+
+	return result, nil
+}
+
+func lookupVariable(envLookup project.EnvironmentLookup, name string) string {
+	value := ""
+	for _, entry := range envLookup.Lookup(name, "", nil) {
+		value = strings.SplitN(entry, "=", 2)[1]
+	}
+	return value
+}
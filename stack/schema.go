@@ -0,0 +1,334 @@
+package stack
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/rancher-compose/rancher"
+	"gopkg.in/yaml.v2"
+)
+
+// ValidateRancherCompose checks every service entry of a rancher-compose.yml
+// against the fields rancher.RancherConfig understands. Unknown keys, values
+// of the wrong type and entries for services that are not in the
+// docker-compose.yml are all reported together with their line number.
+// Values are interpolated with envLookup first, as the rancher package would.
+// The stack section is checked against Config the same way, as it is.
+func ValidateRancherCompose(dockerCompose, rancherCompose []byte, envLookup project.EnvironmentLookup) error {
+	services := map[string]interface{}{}
+	if err := yaml.Unmarshal(dockerCompose, &services); err != nil {
+		return err
+	}
+
+	all := map[string]interface{}{}
+	if err := yaml.Unmarshal(rancherCompose, &all); err != nil {
+		return err
+	}
+
+	stripped, err := Strip(rancherCompose)
+	if err != nil {
+		return err
+	}
+
+	raw := project.RawServiceMap{}
+	if err := yaml.Unmarshal(stripped, &raw); err != nil {
+		return err
+	}
+
+	if err := project.Interpolate(envLookup, &raw); err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(rancherCompose), "\n")
+	violations := []string{}
+
+	for _, section := range Sections {
+		if values, ok := all[section].(map[interface{}]interface{}); ok {
+			violations = append(violations, checkFields(lines, []string{section}, stringKeys(values), reflect.TypeOf(Config{}))...)
+		} else if value, ok := all[section]; ok && value != nil {
+			violations = append(violations, fmt.Sprintf("line %d: %s must be a map", findLine(lines, section), section))
+		}
+	}
+
+	names := []string{}
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := services[name]; !ok {
+			violations = append(violations, fmt.Sprintf("line %d: service %s is not defined in docker-compose.yml", findLine(lines, name), name))
+		}
+
+		violations = append(violations, checkFields(lines, []string{name}, raw[name], reflect.TypeOf(rancher.RancherConfig{}))...)
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+
+	return nil
+}
+
+var quotedValue = regexp.MustCompile(" `[^`]*`")
+
+func checkFields(lines []string, path []string, values map[string]interface{}, t reflect.Type) []string {
+	violations := []string{}
+	fields := yamlFields(t)
+
+	for _, key := range sortedKeys(values) {
+		value := values[key]
+		keyPath := append(append([]string{}, path...), key)
+		line := findLine(lines, keyPath...)
+
+		field, ok := fields[key]
+		if !ok {
+			violations = append(violations, fmt.Sprintf("line %d: unknown key %s in %s", line, key, strings.Join(path, ".")))
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		if nested, ok := value.(map[interface{}]interface{}); ok && fieldType.Kind() == reflect.Struct {
+			violations = append(violations, checkFields(lines, keyPath, stringKeys(nested), fieldType)...)
+			continue
+		}
+
+		// Lists and maps of structs are checked entry by entry
+		if elem := structType(fieldType); elem != nil {
+			if items, ok := value.([]interface{}); ok && fieldType.Kind() == reflect.Slice {
+				for i, item := range items {
+					itemPath := append(append([]string{}, path...), fmt.Sprintf("%s[%d]", key, i))
+					if nested, ok := item.(map[interface{}]interface{}); ok {
+						violations = append(violations, checkFields(lines, itemPath, stringKeys(nested), elem)...)
+					} else {
+						violations = append(violations, fmt.Sprintf("line %d: %s: must be a map", line, strings.Join(itemPath, ".")))
+					}
+				}
+				continue
+			}
+			if nested, ok := value.(map[interface{}]interface{}); ok && fieldType.Kind() == reflect.Map {
+				entries := stringKeys(nested)
+				for _, name := range sortedKeys(entries) {
+					entryPath := append(append([]string{}, keyPath...), name)
+					if nested, ok := entries[name].(map[interface{}]interface{}); ok {
+						violations = append(violations, checkFields(lines, entryPath, stringKeys(nested), elem)...)
+					} else {
+						violations = append(violations, fmt.Sprintf("line %d: %s: must be a map", findLine(lines, entryPath...), strings.Join(entryPath, ".")))
+					}
+				}
+				continue
+			}
+		}
+
+		if msg := checkType(key, value, t); msg != "" {
+			violations = append(violations, fmt.Sprintf("line %d: %s: %s", line, strings.Join(keyPath, "."), msg))
+		}
+	}
+
+	return violations
+}
+
+// checkType decodes a single key into a fresh t to see if its value has the
+// right type.
+func checkType(key string, value interface{}, t reflect.Type) string {
+	bytes, err := yaml.Marshal(map[string]interface{}{key: value})
+	if err != nil {
+		return err.Error()
+	}
+
+	err = yaml.Unmarshal(bytes, reflect.New(t).Interface())
+	if err == nil {
+		return ""
+	}
+
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		msgs := []string{}
+		for _, msg := range typeErr.Errors {
+			// Line numbers refer to the re-encoded snippet, drop them
+			if parts := strings.SplitN(msg, ": ", 2); len(parts) == 2 && strings.HasPrefix(msg, "line ") {
+				msg = parts[1]
+			}
+			// Values may be interpolated secrets, keep them out of the message
+			msgs = append(msgs, quotedValue.ReplaceAllString(msg, ""))
+		}
+		return strings.Join(msgs, ", ")
+	}
+
+	return err.Error()
+}
+
+// structType returns the struct type of the entries of a slice or map type,
+// or nil if they are not structs.
+func structType(t reflect.Type) reflect.Type {
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Map {
+		return nil
+	}
+	elem := t.Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return nil
+	}
+	return elem
+}
+
+func stringKeys(values map[interface{}]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range values {
+		result[fmt.Sprint(k)] = v
+	}
+	return result
+}
+
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	result := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous || field.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		result[name] = field
+	}
+	return result
+}
+
+var listItem = regexp.MustCompile(`^(.*)\[([0-9]+)\]$`)
+
+// findLine returns the 1-based line of the key at path, or of the deepest
+// parent of it that could be found. A key of the form name[i] is the i-th
+// item of the list under name.
+func findLine(lines []string, path ...string) int {
+	result := 0
+	start := 0
+	parentIndent := -1
+	inItem := false
+
+	for _, key := range path {
+		index := -1
+		if match := listItem.FindStringSubmatch(key); match != nil {
+			key = match[1]
+			index, _ = strconv.Atoi(match[2])
+		}
+
+		line, indent, found := findKey(lines, key, start, parentIndent, inItem)
+		if !found {
+			break
+		}
+		result, start, parentIndent, inItem = line+1, line+1, indent, false
+
+		if index < 0 {
+			continue
+		}
+
+		line, indent, found = findItem(lines, index, start, parentIndent)
+		if !found {
+			break
+		}
+		// The first key of the item is on the line of its dash
+		result, start, parentIndent, inItem = line+1, line, indent, true
+	}
+
+	return result
+}
+
+func findKey(lines []string, key string, start, parentIndent int, inItem bool) (int, int, bool) {
+	childIndent := -1
+
+	for i := start; i < len(lines); i++ {
+		trimmed := strings.TrimLeft(lines[i], " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		indent := len(lines[i]) - len(trimmed)
+		if inItem && i == start {
+			trimmed = strings.TrimLeft(strings.TrimPrefix(trimmed, "-"), " ")
+			indent = len(lines[i]) - len(trimmed)
+		} else if indent <= parentIndent {
+			break
+		}
+		if childIndent == -1 {
+			childIndent = indent
+		}
+		if indent != childIndent {
+			continue
+		}
+
+		if isKey(trimmed, key) {
+			return i, indent, true
+		}
+	}
+
+	return 0, 0, false
+}
+
+func findItem(lines []string, index, start, parentIndent int) (int, int, bool) {
+	dashIndent := -1
+	count := 0
+
+	for i := start; i < len(lines); i++ {
+		trimmed := strings.TrimLeft(lines[i], " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		indent := len(lines[i]) - len(trimmed)
+		// List items may be indented as far as their key
+		if indent < parentIndent || (indent == parentIndent && !strings.HasPrefix(trimmed, "-")) {
+			break
+		}
+		if !strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+		if dashIndent == -1 {
+			dashIndent = indent
+		}
+		if indent != dashIndent {
+			continue
+		}
+
+		if count == index {
+			return i, indent, true
+		}
+		count++
+	}
+
+	return 0, 0, false
+}
+
+func isKey(line, key string) bool {
+	for _, candidate := range []string{key, `"` + key + `"`, "'" + key + "'"} {
+		if strings.HasPrefix(line, candidate) && strings.HasPrefix(strings.TrimLeft(line[len(candidate):], " "), ":") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(data map[string]interface{}) []string {
+	keys := []string{}
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
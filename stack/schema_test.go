@@ -0,0 +1,93 @@
+package stack
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/libcompose/project"
+)
+
+type testEnvLookup map[string]string
+
+func (t testEnvLookup) Lookup(key, serviceName string, config *project.ServiceConfig) []string {
+	if value, ok := t[key]; ok {
+		return []string{key + "=" + value}
+	}
+	return []string{}
+}
+
+func TestValidateRancherCompose(t *testing.T) {
+	dockerCompose := []byte("web:\n  image: nginx\ndb:\n  image: redis\n")
+
+	tests := []struct {
+		name           string
+		rancherCompose string
+		violations     []string
+	}{
+		{
+			name:           "valid",
+			rancherCompose: "web:\n  scale: 2\n  health_check:\n    port: 80\n",
+		},
+		{
+			name:           "unknown key",
+			rancherCompose: "web:\n  scale: 2\ndb:\n  scal: 3\n",
+			violations:     []string{"line 4: unknown key scal in db"},
+		},
+		{
+			name:           "wrong type after interpolation",
+			rancherCompose: "web:\n  scale: ${SCALE}\n",
+			violations:     []string{"line 2: web.scale: cannot unmarshal !!str into int"},
+		},
+		{
+			name:           "nested unknown key",
+			rancherCompose: "web:\n  health_check:\n    port: 80\n    intervall: 2000\n",
+			violations:     []string{"line 4: unknown key intervall in web.health_check"},
+		},
+		{
+			name:           "comments and blank lines",
+			rancherCompose: "# scale\n\nweb:\n\n  # checks\n  health_check:\n    port: 80\n\n    intervall: 2000\n",
+			violations:     []string{"line 9: unknown key intervall in web.health_check"},
+		},
+		{
+			name:           "orphan service",
+			rancherCompose: "web:\n  scale: 1\ncache:\n  scale: 1\n",
+			violations:     []string{"line 3: service cache is not defined in docker-compose.yml"},
+		},
+		{
+			name:           "stack section",
+			rancherCompose: ".stack:\n  questoins:\n  - variable: a\n  defaults:\n    lables:\n      a: b\n  registries:\n  - server: quay.io\n    pasword_variable: X\n  builds:\n    web:\n      dockerfil: FROM busybox\nweb:\n  scale: 1\n",
+			violations: []string{
+				"line 12: unknown key dockerfil in .stack.builds.web",
+				"line 5: unknown key lables in .stack.defaults",
+				"line 2: unknown key questoins in .stack",
+				"line 9: unknown key pasword_variable in .stack.registries[0]",
+			},
+		},
+		{
+			name:           "catalog section",
+			rancherCompose: ".catalog:\n  questions:\n  - variable: a\n    requird: true\n",
+			violations:     []string{"line 4: unknown key requird in .catalog.questions[0]"},
+		},
+	}
+
+	for _, test := range tests {
+		err := ValidateRancherCompose(dockerCompose, []byte(test.rancherCompose), testEnvLookup{"SCALE": "two"})
+		if len(test.violations) == 0 {
+			if err != nil {
+				t.Fatal(test.name, err)
+			}
+			continue
+		}
+
+		validationErr, ok := err.(*ValidationError)
+		if !ok || !reflect.DeepEqual(validationErr.Violations, test.violations) {
+			t.Fatalf("%s: expected %q, got %v", test.name, test.violations, err)
+		}
+	}
+}
+
+func TestParseBothSections(t *testing.T) {
+	if _, err := Parse([]byte(".stack:\n  questions: []\n.catalog:\n  questions: []\n")); err == nil {
+		t.Fatal("Expected a file with both sections to be rejected")
+	}
+}
@@ -0,0 +1,112 @@
+package stack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rancher/rancher-compose/rancher"
+	"gopkg.in/yaml.v2"
+)
+
+// Keys in rancher-compose.yml that hold stack level settings instead of a
+// service. Either one is accepted, but not both.
+var Sections = []string{".stack", ".catalog"}
+
+type Config struct {
+	Questions  []Question             `yaml:"questions,omitempty"`
+	Defaults   rancher.Defaults       `yaml:"defaults,omitempty"`
+	Registries []Registry             `yaml:"registries,omitempty"`
+	Git        []Git                  `yaml:"git,omitempty"`
+	Builds     map[string]InlineBuild `yaml:"builds,omitempty"`
+}
+
+// IsSection returns true if name is a stack level section and not a service.
+func IsSection(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// Parse reads the stack section out of the contents of a rancher-compose.yml.
+// A file without a stack section yields an empty Config, a file with both
+// sections is rejected.
+func Parse(rancherCompose []byte) (*Config, error) {
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(rancherCompose, &raw); err != nil {
+		return nil, err
+	}
+
+	found := []string{}
+	for _, section := range Sections {
+		if _, ok := raw[section]; ok {
+			found = append(found, section)
+		}
+	}
+	if len(found) > 1 {
+		return nil, fmt.Errorf("Only one of %s may be set", strings.Join(found, " and "))
+	}
+
+	for _, section := range Sections {
+		data, ok := raw[section]
+		if !ok {
+			continue
+		}
+
+		bytes, err := yaml.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+
+		config := &Config{}
+		return config, yaml.Unmarshal(bytes, config)
+	}
+
+	return &Config{}, nil
+}
+
+// ExtractSections returns just the stack sections of a rancher-compose.yml
+// that has not been rendered as a template yet, so its questions can be
+// answered before rendering. The rest of such a file may not be valid YAML,
+// in which case the sections are cut out line by line; they can not use
+// template syntax themselves.
+func ExtractSections(rancherCompose []byte) []byte {
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(rancherCompose, &raw); err == nil {
+		return rancherCompose
+	}
+
+	result := []string{}
+	inSection := false
+	for _, line := range strings.Split(string(rancherCompose), "\n") {
+		if line != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, "#") {
+			key := strings.Trim(strings.TrimSpace(strings.SplitN(line, ":", 2)[0]), `"'`)
+			inSection = IsSection(key)
+		}
+		if inSection {
+			result = append(result, line)
+		}
+	}
+
+	return []byte(strings.Join(result, "\n"))
+}
+
+// Strip removes the stack sections from the contents of a rancher-compose.yml
+// so that only service entries are left for the rancher package to parse.
+func Strip(rancherCompose []byte) ([]byte, error) {
+	raw := yaml.MapSlice{}
+	if err := yaml.Unmarshal(rancherCompose, &raw); err != nil {
+		return nil, err
+	}
+
+	services := yaml.MapSlice{}
+	for _, item := range raw {
+		if name, ok := item.Key.(string); ok && IsSection(name) {
+			continue
+		}
+		services = append(services, item)
+	}
+
+	if len(services) == len(raw) {
+		return rancherCompose, nil
+	}
+
+	return yaml.Marshal(services)
+}
@@ -0,0 +1,35 @@
+package stack
+
+import (
+	"testing"
+)
+
+func TestExtractSections(t *testing.T) {
+	tests := []struct {
+		name           string
+		rancherCompose string
+		expected       string
+	}{
+		{
+			name:           "valid yaml",
+			rancherCompose: ".catalog:\n  questions: []\nweb:\n  scale: 2\n",
+			expected:       ".catalog:\n  questions: []\nweb:\n  scale: 2\n",
+		},
+		{
+			name:           "template",
+			rancherCompose: "web:\n  scale: {{ .Values.SCALE }}\n.stack:\n  questions:\n  - variable: SCALE\n# comment\ndb:\n  {{- if .Values.HA }}\n  scale: 2\n  {{- end }}\n",
+			expected:       ".stack:\n  questions:\n  - variable: SCALE\n# comment",
+		},
+		{
+			name:           "quoted section",
+			rancherCompose: "web:\n  scale: {{ .Values.SCALE }}\n'.catalog':\n  questions: []\n",
+			expected:       "'.catalog':\n  questions: []\n",
+		},
+	}
+
+	for _, test := range tests {
+		if result := string(ExtractSections([]byte(test.rancherCompose))); result != test.expected {
+			t.Fatalf("%s: expected %q, got %q", test.name, test.expected, result)
+		}
+	}
+}
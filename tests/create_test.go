@@ -354,8 +354,12 @@ func waitForEnvironmentSuccess(t *testing.T, env *client.Environment) {
 }
 
 func waitForEnvironment(t *testing.T, env *client.Environment) {
+	waitForEnvironmentWithClient(t, apiClient, env)
+}
+
+func waitForEnvironmentWithClient(t *testing.T, cl *client.RancherClient, env *client.Environment) {
 	for {
-		err := apiClient.Reload(&(env.Resource), env)
+		err := cl.Reload(&(env.Resource), env)
 		if err != nil {
 			t.Fatal("Error updating environment, err = ", err)
 		}
@@ -365,3 +369,435 @@ func waitForEnvironment(t *testing.T, env *client.Environment) {
 		time.Sleep(100 * time.Millisecond)
 	}
 }
+
+func TestDependsOnHealthy(t *testing.T) {
+	dockerComposePath := "assets/depends_on_healthy/docker-compose.yml"
+	rancherComposePath := "assets/depends_on_healthy/rancher-compose.yml"
+	env, err := createEnvironment("dependsOn"+randString(), dockerComposePath, rancherComposePath)
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironmentSuccess(t, env)
+
+	var services client.ServiceCollection
+	if err := apiClient.GetLink(env.Resource, "services", &services); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(services.Data) != 2 {
+		t.Fatal("Expected 2 services, got", len(services.Data))
+	}
+
+	for _, service := range services.Data {
+		if service.Name == "db" && service.State != "active" {
+			t.Fatal("Expected db to be activated before web was created, got", service.State)
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	env, err := apiClient.Environment.Create(&client.Environment{
+		Name:           "template" + randString(),
+		DockerCompose:  readFileToString(t, "assets/template/docker-compose.yml"),
+		RancherCompose: readFileToString(t, "assets/template/rancher-compose.yml"),
+		Environment: map[string]interface{}{
+			"ENABLE_BACKUP": "true",
+			"scale":         "2",
+		},
+		Data: map[string]interface{}{
+			"composeTemplate": true,
+		},
+	})
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironmentSuccess(t, env)
+
+	var services client.ServiceCollection
+	if err := apiClient.GetLink(env.Resource, "services", &services); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(services.Data) != 1 {
+		t.Fatal("Expected 1 service, got", len(services.Data))
+	}
+
+	if services.Data[0].Scale != 2 {
+		t.Fatal("Bad scale", services.Data[0].Scale)
+	}
+
+	if len(services.Data[0].SecondaryLaunchConfigs) != 1 {
+		t.Fatal("Expected backup sidekick to be rendered")
+	}
+}
+
+func TestQuestionsViolations(t *testing.T) {
+	env, err := apiClient.Environment.Create(&client.Environment{
+		Name:           "questions" + randString(),
+		DockerCompose:  readFileToString(t, "assets/questions/docker-compose.yml"),
+		RancherCompose: readFileToString(t, "assets/questions/rancher-compose.yml"),
+		Environment: map[string]interface{}{
+			"mode": "staging",
+		},
+	})
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironment(t, env)
+
+	if env.Transitioning != "error" {
+		t.Fatal("Validation passed")
+	}
+
+	for _, msg := range []string{"mode: staging is not one of [dev, prod]", "port is required"} {
+		if !strings.Contains(env.TransitioningMessage, msg) {
+			t.Fatal("Missing violation", msg, "in", env.TransitioningMessage)
+		}
+	}
+}
+
+func TestQuestionsDefaults(t *testing.T) {
+	env, err := apiClient.Environment.Create(&client.Environment{
+		Name:           "questions" + randString(),
+		DockerCompose:  readFileToString(t, "assets/questions/docker-compose.yml"),
+		RancherCompose: readFileToString(t, "assets/questions/rancher-compose.yml"),
+		Environment: map[string]interface{}{
+			"mode": "dev",
+			"port": "8080",
+		},
+	})
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironmentSuccess(t, env)
+
+	var services client.ServiceCollection
+	if err := apiClient.GetLink(env.Resource, "services", &services); err != nil {
+		t.Fatal(err)
+	}
+
+	if services.Data[0].LaunchConfig.ImageUuid != "docker:nginx" {
+		t.Fatal("Default was not applied", services.Data[0].LaunchConfig.ImageUuid)
+	}
+}
+
+func TestBadRancherComposeKeys(t *testing.T) {
+	dockerComposePath := "assets/bad_keys_rancher_compose/docker-compose.yml"
+	rancherComposePath := "assets/bad_keys_rancher_compose/rancher-compose.yml"
+	env, err := createEnvironment("badKeys"+randString(), dockerComposePath, rancherComposePath)
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironment(t, env)
+
+	if env.Transitioning != "error" {
+		t.Fatal("Validation passed")
+	}
+
+	for _, msg := range []string{
+		"line 2: unknown key scal in web",
+		"line 4: web.health_check.port: cannot unmarshal !!str `http` into int64",
+		"line 5: service redis is not defined in docker-compose.yml",
+	} {
+		if !strings.Contains(env.TransitioningMessage, msg) {
+			t.Fatal("Missing violation", msg, "in", env.TransitioningMessage)
+		}
+	}
+}
+
+func TestStackDefaults(t *testing.T) {
+	dockerComposePath := "assets/stack_defaults/docker-compose.yml"
+	rancherComposePath := "assets/stack_defaults/rancher-compose.yml"
+	env, err := createEnvironment("defaults"+randString(), dockerComposePath, rancherComposePath)
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironmentSuccess(t, env)
+
+	var services client.ServiceCollection
+	if err := apiClient.GetLink(env.Resource, "services", &services); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, service := range services.Data {
+		labels := service.LaunchConfig.Labels
+		if labels["io.rancher.scheduler.affinity:host_label"] != "zone=a" {
+			t.Fatal("Missing default label on", service.Name, labels)
+		}
+		if service.Metadata["team"] != "web" {
+			t.Fatal("Missing default metadata on", service.Name, service.Metadata)
+		}
+
+		switch service.Name {
+		case "web":
+			if service.LaunchConfig.Environment["LOG_LEVEL"] != "debug" {
+				t.Fatal("Service environment should win", service.LaunchConfig.Environment)
+			}
+		case "db":
+			if labels["team"] != "storage" {
+				t.Fatal("Service label should win", labels)
+			}
+			if service.LaunchConfig.Environment["LOG_LEVEL"] != "info" {
+				t.Fatal("Missing default environment", service.LaunchConfig.Environment)
+			}
+		}
+	}
+}
+
+func TestCreateUsesProjectKey(t *testing.T) {
+	dockerComposePath := "assets/only_docker_compose/docker-compose.yml"
+	env, err := createEnvironment2("projectKey"+randString(), dockerComposePath, "")
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient2)
+	waitForEnvironmentWithClient(t, apiClient2, env)
+
+	if env.Transitioning != "no" {
+		t.Fatal("Failed to create environment", env.Name, env.TransitioningMessage)
+	}
+
+	keys, err := apiClient2.ApiKey.List(&client.ListOpts{
+		Filters: map[string]interface{}{
+			"name":  "rancher-compose-executor",
+			"state": "active",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys.Data) == 0 {
+		t.Fatal("Expected the executor to create a key scoped to the project")
+	}
+
+	for _, key := range keys.Data {
+		if key.AccountId != env.AccountId {
+			t.Fatal("Key belongs to", key.AccountId, "instead of", env.AccountId)
+		}
+	}
+}
+
+func TestRegistries(t *testing.T) {
+	env, err := apiClient.Environment.Create(&client.Environment{
+		Name:           "registries" + randString(),
+		DockerCompose:  readFileToString(t, "assets/registries/docker-compose.yml"),
+		RancherCompose: readFileToString(t, "assets/registries/rancher-compose.yml"),
+		Environment: map[string]interface{}{
+			"REGISTRY_PASSWORD": "secret",
+		},
+	})
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironmentSuccess(t, env)
+
+	// A second stack must reuse the registry and credential
+	env2, err := apiClient.Environment.Create(&client.Environment{
+		Name:           "registries" + randString(),
+		DockerCompose:  readFileToString(t, "assets/registries/docker-compose.yml"),
+		RancherCompose: readFileToString(t, "assets/registries/rancher-compose.yml"),
+		Environment: map[string]interface{}{
+			"REGISTRY_PASSWORD": "rotated",
+		},
+	})
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env2, apiClient)
+	waitForEnvironmentSuccess(t, env2)
+
+	registries, err := apiClient.Registry.List(&client.ListOpts{
+		Filters: map[string]interface{}{
+			"serverAddress": "registry.example.com",
+			"removed_null":  nil,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registries.Data) != 1 {
+		t.Fatal("Expected one registry, got", len(registries.Data))
+	}
+
+	credentials, err := apiClient.RegistryCredential.List(&client.ListOpts{
+		Filters: map[string]interface{}{
+			"registryId":   registries.Data[0].Id,
+			"removed_null": nil,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credentials.Data) != 1 || credentials.Data[0].PublicValue != "deploy" {
+		t.Fatal("Expected one credential for deploy", credentials.Data)
+	}
+}
+
+func TestRegistriesMissingPassword(t *testing.T) {
+	env, err := createEnvironment("registries"+randString(), "assets/registries/docker-compose.yml", "assets/registries/rancher-compose.yml")
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironment(t, env)
+
+	if env.Transitioning != "error" || !strings.Contains(env.TransitioningMessage, "REGISTRY_PASSWORD") {
+		t.Fatal("Expected missing password error", env.TransitioningMessage)
+	}
+}
+
+func TestMissingReferences(t *testing.T) {
+	env, err := createEnvironment("references"+randString(), "assets/missing_references/docker-compose.yml", "assets/missing_references/rancher-compose.yml")
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironment(t, env)
+
+	if env.Transitioning != "error" {
+		t.Fatal("Pre-flight passed")
+	}
+
+	for _, msg := range []string{
+		"service web: external link nostack/db not found",
+		"service web: volumes_from data is not a service in this stack",
+		"service lb: certificate nocert not found",
+	} {
+		if !strings.Contains(env.TransitioningMessage, msg) {
+			t.Fatal("Missing reference", msg, "in", env.TransitioningMessage)
+		}
+	}
+
+	var services client.ServiceCollection
+	if err := apiClient.GetLink(env.Resource, "services", &services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services.Data) != 0 {
+		t.Fatal("Expected no services to be created", len(services.Data))
+	}
+}
+
+func TestInlineBuild(t *testing.T) {
+	env, err := createEnvironment("inline"+randString(), "assets/inline_build/docker-compose.yml", "assets/inline_build/rancher-compose.yml")
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironmentSuccess(t, env)
+
+	var services client.ServiceCollection
+	if err := apiClient.GetLink(env.Resource, "services", &services); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(services.Data) != 1 || services.Data[0].LaunchConfig.Build == nil || services.Data[0].LaunchConfig.Build.Context == "" {
+		t.Fatal("Expected service to build from an uploaded context", services.Data)
+	}
+}
+
+func TestSharedBuild(t *testing.T) {
+	env, err := createEnvironment("shared"+randString(), "assets/shared_build/docker-compose.yml", "assets/shared_build/rancher-compose.yml")
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironmentSuccess(t, env)
+
+	var services client.ServiceCollection
+	if err := apiClient.GetLink(env.Resource, "services", &services); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(services.Data) != 2 {
+		t.Fatal("Expected two services", len(services.Data))
+	}
+
+	first, second := services.Data[0].LaunchConfig, services.Data[1].LaunchConfig
+	if first.Build == nil || second.Build == nil || first.ImageUuid != second.ImageUuid || first.Build.Context != second.Build.Context {
+		t.Fatal("Expected services to share one build", first.ImageUuid, second.ImageUuid)
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	env, err := apiClient.Environment.Create(&client.Environment{
+		Name:           "buildargs" + randString(),
+		DockerCompose:  readFileToString(t, "assets/build_args/docker-compose.yml"),
+		RancherCompose: readFileToString(t, "assets/build_args/rancher-compose.yml"),
+		Environment: map[string]interface{}{
+			"STABLE_VERSION": "1.0",
+			"CANARY_VERSION": "1.1",
+		},
+	})
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironmentSuccess(t, env)
+
+	var services client.ServiceCollection
+	if err := apiClient.GetLink(env.Resource, "services", &services); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(services.Data) != 2 {
+		t.Fatal("Expected two services", len(services.Data))
+	}
+
+	// The same Dockerfile with different args must not share a build
+	first, second := services.Data[0].LaunchConfig, services.Data[1].LaunchConfig
+	if first.Build == nil || second.Build == nil || first.ImageUuid == second.ImageUuid {
+		t.Fatal("Expected separate builds", first.ImageUuid, second.ImageUuid)
+	}
+}
+
+func TestBuildTargetMissing(t *testing.T) {
+	env, err := apiClient.Environment.Create(&client.Environment{
+		Name:           "buildtarget" + randString(),
+		DockerCompose:  "web:\n  build:\n    context: .\n    target: missing\n",
+		RancherCompose: ".stack:\n  builds:\n    web:\n      dockerfile: FROM busybox\n",
+	})
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironment(t, env)
+
+	if env.Transitioning != "error" || !strings.Contains(env.TransitioningMessage, "not a stage") {
+		t.Fatal("Expected missing build target to fail", env.TransitioningMessage)
+	}
+}
+
+func TestPrePullUnknownImage(t *testing.T) {
+	env, err := apiClient.Environment.Create(&client.Environment{
+		Name:          "prepull" + randString(),
+		DockerCompose: "web:\n  image: rancher/does-not-exist-" + strings.ToLower(randString()) + "\n",
+		Data: map[string]interface{}{
+			"prePull": true,
+		},
+	})
+	if err != nil {
+		t.Fatal("Error creating environment, err = ", err)
+	}
+	defer deleteEnvironment(env, apiClient)
+	waitForEnvironment(t, env)
+
+	if env.Transitioning != "error" || !strings.Contains(env.TransitioningMessage, "Failed to pull images") {
+		t.Fatal("Expected pull to fail", env.TransitioningMessage)
+	}
+
+	var services client.ServiceCollection
+	if err := apiClient.GetLink(env.Resource, "services", &services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services.Data) != 0 {
+		t.Fatal("Expected no services to be created", len(services.Data))
+	}
+}
@@ -0,0 +1,191 @@
+package uploader
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libcompose/project"
+	"github.com/rancher/rancher-compose/rancher"
+)
+
+// CacheEntry is what an upload of a build context returned. Used is the last
+// time the context was uploaded or reused. The URL is only kept for uploaders
+// that are not a Signer.
+type CacheEntry struct {
+	Image   string    `json:"image"`
+	Url     string    `json:"url,omitempty"`
+	Expires time.Time `json:"expires"`
+	Used    time.Time `json:"used"`
+}
+
+// Deleter is implemented by uploaders that can delete a stored context.
+type Deleter interface {
+	Delete(hash string) error
+}
+
+// Signer is implemented by uploaders that can hand out a new URL for a
+// stored context. It fails if the context is no longer stored.
+type Signer interface {
+	Sign(hash string) (string, error)
+}
+
+// Cache remembers the image returned for every build context hash so an
+// unchanged context is not uploaded again and keeps its image name. A Signer
+// is asked for a new URL on every reuse, the URL of other uploaders is kept
+// and reused while at least half of its lifetime is left. Entries
+// stay in the index until they are removed, so the stored contexts can be
+// garbage collected. The index is kept in File so it survives restarts.
+type Cache struct {
+	Uploader rancher.Uploader
+	File     string
+	TTL      time.Duration
+
+	lock    sync.Mutex
+	entries map[string]CacheEntry
+}
+
+func NewCache(uploader rancher.Uploader, file string, ttl time.Duration) *Cache {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+
+	c := &Cache{
+		Uploader: uploader,
+		File:     file,
+		TTL:      ttl,
+		entries:  map[string]CacheEntry{},
+	}
+
+	if content, err := ioutil.ReadFile(file); err == nil {
+		if err := json.Unmarshal(content, &c.entries); err != nil {
+			logrus.Warnf("Ignoring build cache index %s: %v", file, err)
+			c.entries = map[string]CacheEntry{}
+		}
+	} else if !os.IsNotExist(err) {
+		logrus.Warnf("Ignoring build cache index %s: %v", file, err)
+	}
+
+	return c
+}
+
+func (c *Cache) Name() string {
+	return c.Uploader.Name()
+}
+
+func (c *Cache) Upload(p *project.Project, name string, reader io.ReadSeeker, hash string) (string, string, error) {
+	if entry, ok := c.Get(hash); ok {
+		url, err := c.url(hash, entry)
+		if err == nil {
+			logrus.Infof("Reusing build context %s for %s as %s", hash[:12], name, entry.Image)
+			entry.Used = time.Now()
+			c.put(hash, entry)
+			return entry.Image, url, nil
+		}
+		logrus.Infof("Uploading build context %s for %s again: %v", hash[:12], name, err)
+	}
+
+	expires := time.Now().Add(c.TTL)
+	image, url, err := c.Uploader.Upload(p, name, reader, hash)
+	if err != nil {
+		return "", "", err
+	}
+
+	entry := CacheEntry{
+		Image:   image,
+		Expires: expires,
+		Used:    time.Now(),
+	}
+	// URLs of a Signer are made fresh on every reuse, as they may be signed
+	// with a key that does not survive a restart
+	if _, ok := c.Uploader.(Signer); !ok {
+		entry.Url = url
+	}
+	c.put(hash, entry)
+
+	return image, url, nil
+}
+
+func (c *Cache) url(hash string, entry CacheEntry) (string, error) {
+	if signer, ok := c.Uploader.(Signer); ok {
+		return signer.Sign(hash)
+	}
+	return entry.Url, nil
+}
+
+// Get returns the entry for hash if it can still be used. Entries of a Signer
+// can be used as long as they are in the index, others while at least half
+// of their URL lifetime is left.
+func (c *Cache) Get(hash string) (CacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[hash]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	if _, signer := c.Uploader.(Signer); !signer && time.Now().Add(c.TTL/2).After(entry.Expires) {
+		return CacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *Cache) put(hash string, entry CacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries[hash] = entry
+	if err := c.save(); err != nil {
+		logrus.Warnf("Failed to save build cache index %s: %v", c.File, err)
+	}
+}
+
+// Entries returns a copy of the index.
+func (c *Cache) Entries() map[string]CacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result := map[string]CacheEntry{}
+	for hash, entry := range c.entries {
+		result[hash] = entry
+	}
+	return result
+}
+
+// Remove deletes the stored context, if the uploader supports it, and drops
+// it from the index.
+func (c *Cache) Remove(hash string) error {
+	if deleter, ok := c.Uploader.(Deleter); ok {
+		if err := deleter.Delete(hash); err != nil {
+			return err
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.entries, hash)
+	return c.save()
+}
+
+func (c *Cache) save() error {
+	if c.File == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+
+	temp := c.File + ".tmp"
+	if err := ioutil.WriteFile(temp, content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, c.File)
+}
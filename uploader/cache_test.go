@@ -0,0 +1,162 @@
+package uploader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/libcompose/project"
+)
+
+type countingUploader struct {
+	uploads int
+}
+
+func (c *countingUploader) Name() string {
+	return "counting"
+}
+
+func (c *countingUploader) Upload(p *project.Project, name string, reader io.ReadSeeker, hash string) (string, string, error) {
+	c.uploads++
+	return name + "-" + hash[:12], "http://builds/" + hash, nil
+}
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "index.json")
+	hash := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	counting := &countingUploader{}
+
+	cache := NewCache(counting, file, time.Hour)
+	first, _, err := cache.Upload(nil, "web", bytes.NewReader(nil), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new cache reads the index written by the first one
+	cache = NewCache(counting, file, time.Hour)
+	second, url, err := cache.Upload(nil, "other", bytes.NewReader(nil), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if counting.uploads != 1 || first != second || url != "http://builds/"+hash {
+		t.Fatal("Expected cached upload", counting.uploads, first, second, url)
+	}
+
+	// Entries with less than half of their lifetime left are uploaded again
+	cache.entries[hash] = CacheEntry{Image: first, Url: url, Expires: time.Now().Add(10 * time.Minute)}
+	if _, _, err := cache.Upload(nil, "web", bytes.NewReader(nil), hash); err != nil {
+		t.Fatal(err)
+	}
+	if counting.uploads != 2 {
+		t.Fatal("Expected expiring entry to be uploaded again")
+	}
+}
+
+type deletingUploader struct {
+	countingUploader
+	deleted []string
+}
+
+func (d *deletingUploader) Delete(hash string) error {
+	d.deleted = append(d.deleted, hash)
+	return nil
+}
+
+func TestCacheRemove(t *testing.T) {
+	hash := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	deleting := &deletingUploader{}
+
+	cache := NewCache(deleting, "", time.Hour)
+	if _, _, err := cache.Upload(nil, "web", bytes.NewReader(nil), hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Entries()[hash]; !ok {
+		t.Fatal("Expected entry for upload")
+	}
+
+	if err := cache.Remove(hash); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleting.deleted) != 1 || len(cache.Entries()) != 0 {
+		t.Fatal("Expected context to be deleted and dropped", deleting.deleted, cache.Entries())
+	}
+}
+
+func TestCacheSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "index.json")
+	content := []byte("tar content")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	local, err := NewLocal(dir, "http://builds", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewCache(local, file, time.Hour).Upload(nil, "web", bytes.NewReader(content), hash); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(index), "signature") {
+		t.Fatal("Expected signed URLs to be left out of the index", string(index))
+	}
+
+	// After a restart without a signing key the URL is signed with the new one
+	restarted, err := NewLocal(dir, "", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(restarted)
+	defer server.Close()
+	restarted.Url = server.URL
+
+	_, url, err := NewCache(restarted, file, time.Hour).Upload(nil, "web", bytes.NewReader(nil), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("Expected reused URL to be valid after a restart, got", resp.Status)
+	}
+
+	// A context that is no longer stored is uploaded again
+	if err := restarted.Delete(hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewCache(restarted, file, time.Hour).Upload(nil, "web", bytes.NewReader(content), hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(dir, hash+".tar")); err != nil {
+		t.Fatal("Expected context to be stored again", err)
+	}
+}
@@ -0,0 +1,162 @@
+package uploader
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libcompose/project"
+)
+
+const (
+	DefaultTTL = 24 * time.Hour
+
+	pathPrefix = "/builds/"
+)
+
+var archiveName = regexp.MustCompile("^[0-9a-f]{64}\\.tar$")
+
+// Local stores build contexts in Dir, named by the sha256 of their content,
+// and serves them over HTTP. Every URL it hands out is signed with Key and
+// expires after TTL, so agents can fetch a context without credentials but
+// nobody can list or guess others.
+type Local struct {
+	Dir string
+	Url string
+	Key []byte
+	TTL time.Duration
+}
+
+// NewLocal returns a Local uploader. Without a key a random one is generated,
+// which means URLs handed out before a restart stop working.
+func NewLocal(dir, url string, key []byte, ttl time.Duration) (*Local, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Local{
+		Dir: dir,
+		Url: strings.TrimSuffix(url, "/"),
+		Key: key,
+		TTL: ttl,
+	}, nil
+}
+
+func (l *Local) Name() string {
+	return "local"
+}
+
+func (l *Local) Upload(p *project.Project, name string, reader io.ReadSeeker, hash string) (string, string, error) {
+	file := path.Join(l.Dir, hash+".tar")
+
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		if err := l.store(file, reader); err != nil {
+			return "", "", err
+		}
+	} else if err != nil {
+		return "", "", err
+	} else {
+		logrus.Debugf("Build context %s for %s already stored", hash, name)
+	}
+
+	return fmt.Sprintf("%s-%s", name, hash[:12]), l.SignedUrl(hash, time.Now().Add(l.TTL)), nil
+}
+
+// Sign returns a new signed URL of a stored context.
+func (l *Local) Sign(hash string) (string, error) {
+	if _, err := os.Stat(path.Join(l.Dir, hash+".tar")); err != nil {
+		return "", err
+	}
+	return l.SignedUrl(hash, time.Now().Add(l.TTL)), nil
+}
+
+func (l *Local) store(file string, reader io.Reader) error {
+	temp, err := ioutil.TempFile(l.Dir, ".upload")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := io.Copy(temp, reader); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), file)
+}
+
+func (l *Local) Delete(hash string) error {
+	err := os.Remove(path.Join(l.Dir, hash+".tar"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) signature(hash string, expires int64) string {
+	mac := hmac.New(sha256.New, l.Key)
+	fmt.Fprintf(mac, "%s\n%d", hash, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedUrl returns the URL of the context with the given hash that is valid
+// until expires.
+func (l *Local) SignedUrl(hash string, expires time.Time) string {
+	unix := expires.Unix()
+	return fmt.Sprintf("%s%s%s.tar?expires=%d&signature=%s", l.Url, pathPrefix, hash, unix, l.signature(hash, unix))
+}
+
+func (l *Local) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, pathPrefix)
+	if !strings.HasPrefix(req.URL.Path, pathPrefix) || !archiveName.MatchString(name) {
+		http.NotFound(rw, req)
+		return
+	}
+	hash := strings.TrimSuffix(name, ".tar")
+
+	expires, err := strconv.ParseInt(req.URL.Query().Get("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(req.URL.Query().Get("signature")), []byte(l.signature(hash, expires))) {
+		http.Error(rw, "Invalid signature", http.StatusForbidden)
+		return
+	}
+
+	if time.Now().Unix() > expires {
+		http.Error(rw, "URL expired", http.StatusForbidden)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/x-tar")
+	http.ServeFile(rw, req, path.Join(l.Dir, name))
+}
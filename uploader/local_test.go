@@ -0,0 +1,79 @@
+package uploader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	local, err := NewLocal(dir, "", nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(local)
+	defer server.Close()
+	local.Url = server.URL
+
+	content := []byte("tar content")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	image, url, err := local.Upload(nil, "web", bytes.NewReader(content), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image != "web-"+hash[:12] {
+		t.Fatal("Unexpected image", image)
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Fatal("Unexpected response", resp.Status, string(body))
+	}
+
+	// Uploading the same content again reuses the stored archive
+	if _, _, err := local.Upload(nil, "other", bytes.NewReader(content), hash); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatal("Expected one stored archive, got", len(files))
+	}
+
+	for _, bad := range []string{
+		strings.Replace(url, "signature=", "signature=0", 1),
+		strings.Replace(url, "expires=", "expires=1", 1),
+		local.SignedUrl(hash, time.Now().Add(-time.Minute)),
+		server.URL + "/builds/" + hash + ".tar",
+	} {
+		resp, err := http.Get(bad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatal("Expected", bad, "to be forbidden, got", resp.Status)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package uploader
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/awslabs/aws-sdk-go/aws"
+	"github.com/awslabs/aws-sdk-go/aws/awserr"
+	"github.com/awslabs/aws-sdk-go/aws/credentials"
+	"github.com/awslabs/aws-sdk-go/service/s3"
+	"github.com/docker/libcompose/project"
+)
+
+// S3Config points the S3 uploader at AWS or any S3 compatible store. Without
+// an access key the usual AWS environment, shared file and instance role
+// credentials are used. Tags are set on every object so bucket lifecycle
+// rules can expire old build contexts.
+type S3Config struct {
+	Endpoint  string            `yaml:"endpoint,omitempty"`
+	Region    string            `yaml:"region,omitempty"`
+	Bucket    string            `yaml:"bucket,omitempty"`
+	Prefix    string            `yaml:"prefix,omitempty"`
+	AccessKey string            `yaml:"access_key,omitempty"`
+	SecretKey string            `yaml:"secret_key,omitempty"`
+	PathStyle bool              `yaml:"path_style,omitempty"`
+	Tags      map[string]string `yaml:"tags,omitempty"`
+}
+
+// S3 stores build contexts in a single bucket under Prefix, named by the
+// sha256 of their content, and hands out pre-signed GET URLs.
+type S3 struct {
+	config S3Config
+	svc    *s3.S3
+	ttl    time.Duration
+
+	bucketLock  sync.Mutex
+	bucketReady bool
+}
+
+func NewS3(c S3Config, ttl time.Duration) *S3 {
+	awsConfig := aws.DefaultConfig.Copy()
+	awsConfig.Endpoint = c.Endpoint
+	awsConfig.S3ForcePathStyle = c.PathStyle
+	if c.Region != "" {
+		awsConfig.Region = c.Region
+	}
+	if awsConfig.Region == "" {
+		awsConfig.Region = "us-east-1"
+	}
+	if c.AccessKey != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(c.AccessKey, c.SecretKey, "")
+	}
+
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+
+	return &S3{
+		config: c,
+		svc:    s3.New(&awsConfig),
+		ttl:    ttl,
+	}
+}
+
+func (s *S3) Name() string {
+	return "S3"
+}
+
+func (s *S3) Upload(p *project.Project, name string, reader io.ReadSeeker, hash string) (string, string, error) {
+	if err := s.ensureBucket(); err != nil {
+		return "", "", err
+	}
+
+	key := s.config.Prefix + hash + ".tar"
+	exists, err := s.exists(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	if exists {
+		logrus.Debugf("Build context %s for %s already stored", hash, name)
+	} else if err := s.put(key, reader); err != nil {
+		return "", "", err
+	}
+
+	url, err := s.presign(key)
+	return fmt.Sprintf("%s-%s", name, hash[:12]), url, err
+}
+
+// Sign returns a new pre-signed URL of a stored context.
+func (s *S3) Sign(hash string) (string, error) {
+	key := s.config.Prefix + hash + ".tar"
+	exists, err := s.exists(key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("%s is not stored", key)
+	}
+	return s.presign(key)
+}
+
+func (s *S3) presign(key string) (string, error) {
+	req, _ := s.svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: &s.config.Bucket,
+		Key:    &key,
+	})
+
+	return req.Presign(s.ttl)
+}
+
+func (s *S3) Delete(hash string) error {
+	key := s.config.Prefix + hash + ".tar"
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: &s.config.Bucket,
+		Key:    &key,
+	})
+	return err
+}
+
+func (s *S3) ensureBucket() error {
+	s.bucketLock.Lock()
+	defer s.bucketLock.Unlock()
+
+	if s.bucketReady {
+		return nil
+	}
+
+	_, err := s.svc.HeadBucket(&s3.HeadBucketInput{
+		Bucket: &s.config.Bucket,
+	})
+
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == 404 {
+		logrus.Infof("Creating bucket %s", s.config.Bucket)
+		_, err = s.svc.CreateBucket(&s3.CreateBucketInput{
+			Bucket: &s.config.Bucket,
+		})
+	}
+
+	s.bucketReady = err == nil
+	return err
+}
+
+func (s *S3) exists(key string) (bool, error) {
+	_, err := s.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: &s.config.Bucket,
+		Key:    &key,
+	})
+
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == 404 {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (s *S3) put(key string, reader io.ReadSeeker) error {
+	req, _ := s.svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: &s.config.Bucket,
+		Key:    &key,
+		Body:   reader,
+	})
+
+	// The vendored SDK predates object tagging, so the header is set directly
+	if len(s.config.Tags) > 0 {
+		req.HTTPRequest.Header.Set("x-amz-tagging", encodeTags(s.config.Tags))
+	}
+
+	return req.Send()
+}
+
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return strings.Replace(values.Encode(), "+", "%20", -1)
+}
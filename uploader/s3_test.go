@@ -0,0 +1,117 @@
+package uploader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a minimal path style S3 stand-in that keeps objects in memory.
+type fakeS3 struct {
+	lock    sync.Mutex
+	buckets map[string]bool
+	objects map[string][]byte
+	tags    map[string]string
+	puts    int
+}
+
+func (f *fakeS3) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+
+	if len(parts) == 1 || parts[1] == "" {
+		switch req.Method {
+		case "HEAD":
+			if !f.buckets[bucket] {
+				rw.WriteHeader(http.StatusNotFound)
+			}
+		case "PUT":
+			f.buckets[bucket] = true
+		}
+		return
+	}
+
+	if !f.buckets[bucket] {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	key := req.URL.Path
+	switch req.Method {
+	case "HEAD", "GET":
+		content, ok := f.objects[key]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Write(content)
+	case "PUT":
+		content, _ := ioutil.ReadAll(req.Body)
+		f.objects[key] = content
+		f.tags[key] = req.Header.Get("x-amz-tagging")
+		f.puts++
+	}
+}
+
+func TestS3(t *testing.T) {
+	fake := &fakeS3{
+		buckets: map[string]bool{},
+		objects: map[string][]byte{},
+		tags:    map[string]string{},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	uploader := NewS3(S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "builds",
+		Prefix:    "contexts/",
+		AccessKey: "access",
+		SecretKey: "secret",
+		PathStyle: true,
+		Tags:      map[string]string{"expire": "7d"},
+	}, time.Hour)
+
+	hash := strings.Repeat("ab", 32)
+	content := []byte("tar content")
+
+	for _, name := range []string{"web", "worker"} {
+		image, url, err := uploader.Upload(nil, name, bytes.NewReader(content), hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if image != name+"-"+hash[:12] {
+			t.Fatal("Unexpected image", image)
+		}
+
+		if !strings.HasPrefix(url, server.URL+"/builds/contexts/"+hash+".tar?") || !strings.Contains(url, "X-Amz-Signature=") {
+			t.Fatal("Unexpected URL", url)
+		}
+	}
+
+	if !fake.buckets["builds"] || len(fake.buckets) != 1 {
+		t.Fatal("Expected a single builds bucket", fake.buckets)
+	}
+
+	if fake.puts != 1 {
+		t.Fatal("Expected one upload, got", fake.puts)
+	}
+
+	key := "/builds/contexts/" + hash + ".tar"
+	if !bytes.Equal(fake.objects[key], content) {
+		t.Fatal("Unexpected content", string(fake.objects[key]))
+	}
+
+	if fake.tags[key] != "expire=7d" {
+		t.Fatal("Unexpected tags", fake.tags[key])
+	}
+}